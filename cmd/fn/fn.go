@@ -1,17 +1,52 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	gocontext "context"
+	"errors"
 	"fmt"
 	"github.com/xiam/fnlang"
-	_ "github.com/xiam/fnlang/stdlib"
+	"github.com/xiam/fnlang/context"
+	"github.com/xiam/fnlang/stdlib"
+	"github.com/xiam/sexpr/ast"
 	"github.com/xiam/sexpr/parser"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	prompt         = "fn> "
+	promptContinue = "... "
 )
 
 func main() {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	if path := os.Getenv("FNPATH"); path != "" {
+		interp.SetPath(filepath.SplitList(path)...)
+	}
+
+	if n := os.Getenv("FNPARALLELISM"); n != "" {
+		parallelism, err := strconv.Atoi(n)
+		if err != nil {
+			log.Fatal("FNPARALLELISM: ", err)
+		}
+		interp.SetParallelism(parallelism)
+	}
+
+	if isTerminal(os.Stdin) {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		repl(interp, os.Stdin, os.Stdout, interrupt)
+		return
+	}
+
 	buf := bytes.NewBuffer(nil)
 	_, err := io.Copy(buf, os.Stdin)
 	if err != nil {
@@ -21,9 +56,136 @@ func main() {
 	if err != nil {
 		log.Fatal("parser.Parse: ", err)
 	}
-	_, result, err := fnlang.Eval(root)
-	if err != nil {
+	_, result, err := interp.Eval(root)
+	var rtErr *fnlang.Error
+	if err != nil && !errors.As(err, &rtErr) {
 		log.Fatal("fnlang.Eval: ", err)
 	}
 	fmt.Printf("%s\n", result)
+	if rtErr != nil {
+		fmt.Fprint(os.Stderr, rtErr.Traceback())
+		os.Exit(1)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// repl reads forms from r and evaluates them one at a time on a single
+// session, so bindings survive from one line to the next. A value received
+// on interrupt cancels the form being evaluated, if any, while the values,
+// generators and tasks created by earlier forms are kept alive.
+func repl(interp *fnlang.Interpreter, r io.Reader, w io.Writer, interrupt <-chan os.Signal) {
+	session := interp.NewSession()
+	scanner := bufio.NewScanner(r)
+
+	// Everything the session starts is bound to goctx, each form is given a
+	// context of its own on top of it so that it can be cancelled alone.
+	goctx := gocontext.Background()
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprint(w, prompt)
+	for scanner.Scan() {
+		buf.Write(scanner.Bytes())
+		buf.WriteByte('\n')
+
+		if depth(buf.Bytes()) > 0 {
+			fmt.Fprint(w, promptContinue)
+			continue
+		}
+
+		src := buf.Bytes()
+		buf = bytes.NewBuffer(nil)
+
+		root, err := parser.Parse(src)
+		if err != nil {
+			fmt.Fprintf(w, "syntax error: %v\n", err)
+			fmt.Fprint(w, prompt)
+			continue
+		}
+
+		values, err := evalForm(goctx, session, root, interrupt)
+		for i := range values {
+			fmt.Fprintf(w, "%s\n", values[i])
+		}
+		if err != nil {
+			var rtErr *fnlang.Error
+			if errors.As(err, &rtErr) {
+				fmt.Fprint(w, rtErr.Traceback())
+			} else {
+				fmt.Fprintf(w, "error: %v\n", err)
+			}
+		}
+
+		fmt.Fprint(w, prompt)
+	}
+	fmt.Fprintln(w)
+
+	if err := scanner.Err(); err != nil {
+		log.Fatal("bufio.Scanner: ", err)
+	}
+}
+
+// evalForm evaluates root on session until it is done or a value is received
+// on interrupt. Interrupts that arrived while no form was running are
+// dropped.
+func evalForm(goctx gocontext.Context, session *fnlang.Session, root *ast.Node, interrupt <-chan os.Signal) ([]*context.Value, error) {
+	for drained := false; !drained; {
+		select {
+		case <-interrupt:
+		default:
+			drained = true
+		}
+	}
+
+	formCtx, cancel := gocontext.WithCancel(goctx)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-done:
+		}
+	}()
+
+	return session.EvalContext(formCtx, root)
+}
+
+// depth returns the number of brackets that are still open in src, ignoring
+// the ones that appear within strings or comments.
+func depth(src []byte) int {
+	n := 0
+	inString, inComment, escaped := false, false, false
+	for _, c := range src {
+		switch {
+		case inComment:
+			if c == '\n' {
+				inComment = false
+			}
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '#':
+			inComment = true
+		case c == '(' || c == '[' || c == '{':
+			n++
+		case c == ')' || c == ']' || c == '}':
+			n--
+		}
+	}
+	return n
 }
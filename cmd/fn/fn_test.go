@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+	"github.com/xiam/fnlang/stdlib"
+)
+
+func TestDepth(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out int
+	}{
+		{In: `(+ 1 2)`, Out: 0},
+		{In: `(defn f [x]`, Out: 2},
+		{In: `{:a [1 (`, Out: 3},
+		{In: `)`, Out: -1},
+		{In: `(str "(")`, Out: 0},
+		{In: `(str "\"(" `, Out: 1},
+		{In: `(str "\\" (`, Out: 2},
+		{In: "(+ 1 # (((\n", Out: 1},
+		{In: "# )\n(", Out: 1},
+		{In: `"# ("`, Out: 0},
+	}
+
+	for i := range testCases {
+		assert.Equal(t, testCases[i].Out, depth([]byte(testCases[i].In)), testCases[i].In)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	in := strings.Join([]string{
+		`(defn square [x]`,
+		`  (* x x))`,
+		`(set n 12) (square n)`,
+		`(+ 1 (missing))`,
+		`(str "(" `,
+		`  ")")`,
+		`(defn gen [] (let [] (yield 1) (yield 2)))`,
+		`(set g (gen))`,
+		`(take 2 g)`,
+		`(set f (go (+ 1 2)))`,
+		`(await f)`,
+	}, "\n") + "\n"
+
+	out := bytes.NewBuffer(nil)
+	repl(interp, strings.NewReader(in), out, make(chan os.Signal))
+
+	assert.True(t, strings.HasPrefix(out.String(), "fn> ... :true\nfn> :true\n144\n"), out.String())
+	assert.Contains(t, out.String(), `fn> runtime error: no such key: "missing"`)
+
+	assert.True(t, strings.HasSuffix(out.String(), strings.Join([]string{
+		`fn> ... "()"`,
+		`fn> :true`,
+		`fn> :true`,
+		`fn> [1 2]`,
+		`fn> :true`,
+		`fn> 3`,
+		`fn> `,
+	}, "\n")+"\n"), out.String())
+}
+
+func TestREPLInterrupt(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	entered := make(chan struct{})
+	interp.Defn("util/block", func(ctx *context.Context) error {
+		close(entered)
+		<-ctx.Context().Done()
+		return ctx.Err()
+	})
+
+	// Interrupts that come while no form is running are dropped, so this one
+	// is only sent once util/block is running.
+	interrupt := make(chan os.Signal)
+	go func() {
+		<-entered
+		interrupt <- os.Interrupt
+	}()
+
+	out := bytes.NewBuffer(nil)
+	repl(interp, strings.NewReader("(util/block)\n(+ 1 1)\n"), out, interrupt)
+
+	assert.Contains(t, out.String(), context.ErrCanceled.Error())
+	assert.True(t, strings.HasSuffix(out.String(), "fn> 2\nfn> \n"), out.String())
+}
@@ -0,0 +1,150 @@
+package context
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// ErrChanClosed is returned when sending on or closing a channel that was
+// already closed.
+var ErrChanClosed = errors.New("send on closed channel")
+
+// Chan is a channel fn code can send values on and receive values from.
+// Unlike Go channels, sending on a closed channel or closing it twice is an
+// error rather than a panic. Values sent before the channel is closed can
+// still be received after it is.
+type Chan struct {
+	ch     chan *Value
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewChan creates a channel that holds up to size values before sending
+// blocks. A channel of size 0 is unbuffered.
+func NewChan(size int) *Chan {
+	return &Chan{
+		ch:     make(chan *Value, size),
+		closed: make(chan struct{}),
+	}
+}
+
+// Close closes c, receivers get the values that are left on c and :nil after
+// that.
+func (c *Chan) Close() error {
+	closed := false
+	c.once.Do(func() {
+		close(c.closed)
+		closed = true
+	})
+	if !closed {
+		return errors.New("close of closed channel")
+	}
+	return nil
+}
+
+// Send sends value on c, blocking until it is received or buffered.
+func (c *Chan) Send(ctx *Context, value *Value) error {
+	_, _, err := Select(ctx, []SelectCase{{Chan: c, Send: value}}, true)
+	return err
+}
+
+// Recv receives a value from c, blocking until there is one. It reports false
+// once c is closed and has no values left.
+func (c *Chan) Recv(ctx *Context) (*Value, bool, error) {
+	_, value, err := Select(ctx, []SelectCase{{Chan: c}}, true)
+	if err != nil {
+		return nil, false, err
+	}
+	if value == nil {
+		return Nil, false, nil
+	}
+	return value, true, nil
+}
+
+// SelectCase is a channel operation for Select, it sends Send on Chan if
+// Send is set and receives from Chan otherwise.
+type SelectCase struct {
+	Chan *Chan
+	Send *Value
+}
+
+// Select waits until one of the cases can proceed and returns its index,
+// along with the value received if it is a receive. The value is nil for a
+// receive on a closed channel that has no values left. If block is not set
+// and no case can proceed right away Select returns -1.
+func Select(ctx *Context, cases []SelectCase, block bool) (int, *Value, error) {
+	// Sending on a closed channel fails even if there is room left on it.
+	for i, c := range cases {
+		if c.Send == nil {
+			continue
+		}
+		select {
+		case <-c.Chan.closed:
+			return i, nil, ErrChanClosed
+		default:
+		}
+	}
+
+	// Each case waits on its channel and on the channel being closed, so
+	// case i is made of the select cases 2*i and 2*i+1.
+	selectCases := make([]reflect.SelectCase, 0, 2*len(cases)+2)
+
+	for _, c := range cases {
+		if c.Send != nil {
+			selectCases = append(selectCases, reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(c.Chan.ch),
+				Send: reflect.ValueOf(c.Send),
+			})
+		} else {
+			selectCases = append(selectCases, reflect.SelectCase{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(c.Chan.ch),
+			})
+		}
+		selectCases = append(selectCases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(c.Chan.closed),
+		})
+	}
+
+	selectCases = append(selectCases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ctx.done()),
+	})
+
+	if !block {
+		selectCases = append(selectCases, reflect.SelectCase{
+			Dir: reflect.SelectDefault,
+		})
+	}
+
+	chosen, recv, _ := reflect.Select(selectCases)
+
+	if chosen >= 2*len(cases) {
+		if err := ctx.Err(); err != nil {
+			return -1, nil, err
+		}
+		return -1, nil, nil
+	}
+
+	i, c := chosen/2, cases[chosen/2]
+	if chosen%2 == 0 {
+		if c.Send != nil {
+			return i, nil, nil
+		}
+		return i, recv.Interface().(*Value), nil
+	}
+
+	// The channel is closed.
+	if c.Send != nil {
+		return i, nil, ErrChanClosed
+	}
+	select {
+	case value := <-c.Chan.ch:
+		return i, value, nil
+	default:
+	}
+	return i, nil, nil
+}
@@ -0,0 +1,237 @@
+package context
+
+import (
+	"math"
+	"reflect"
+	"strings"
+)
+
+// typeRank returns the position of the type of value in the order of values
+// of different types. Integers and floats share a rank so that numbers are
+// ordered by what they hold.
+func typeRank(value *Value) ValueType {
+	if value.Type() == ValueTypeInt {
+		return ValueTypeFloat
+	}
+	return value.Type()
+}
+
+// Equal reports whether a and b have the same structure. Numbers are equal
+// when they hold the same number, whether they are integers or floats, lists
+// are equal when their items are, and maps when they hold equal values under
+// the same keys. Functions, sequences, channels, futures and references are
+// only equal to themselves.
+func Equal(a *Value, b *Value) bool {
+	if a == b {
+		return true
+	}
+	if typeRank(a) != typeRank(b) {
+		return false
+	}
+
+	switch a.Type() {
+	case ValueTypeInt, ValueTypeFloat:
+		return compareNumbers(a, b) == 0
+	case ValueTypeList:
+		x, y := a.List(), b.List()
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case ValueTypeMap:
+		x, y := a.Map(), b.Map()
+		if x.Len() != y.Len() {
+			return false
+		}
+		for _, bucket := range x.buckets {
+			for _, entry := range bucket {
+				v, ok := y.Get(entry.key)
+				if !ok || !Equal(entry.value, v) {
+					return false
+				}
+			}
+		}
+		return true
+	case ValueTypeFunction:
+		return sameFunction(a, b)
+	case ValueTypeSeq, ValueTypeChan, ValueTypeFuture, ValueTypeRef:
+		return a.v == b.v
+	}
+
+	return a.v.(string) == b.v.(string)
+}
+
+// Compare returns -1, 0 or 1 depending on whether a goes before, is equal to
+// or goes after b. Every pair of values can be compared: values of different
+// types are ordered by type, numbers first, then symbols, atoms, strings,
+// maps, lists, functions, sequences, channels, futures and last references,
+// the values made by the atom builtin. Compare returns 0 only for Equal
+// values.
+func Compare(a *Value, b *Value) int {
+	if a == b {
+		return 0
+	}
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch a.Type() {
+	case ValueTypeInt, ValueTypeFloat:
+		return compareNumbers(a, b)
+	case ValueTypeList:
+		return compareLists(a.List(), b.List())
+	case ValueTypeMap:
+		return compareMaps(a.Map(), b.Map())
+	case ValueTypeFunction:
+		if sameFunction(a, b) {
+			return 0
+		}
+		if c := strings.Compare(a.String(), b.String()); c != 0 {
+			return c
+		}
+		return comparePointers(a, b)
+	case ValueTypeSeq, ValueTypeChan, ValueTypeFuture, ValueTypeRef:
+		if a.v == b.v {
+			return 0
+		}
+		return comparePointers(a.v, b.v)
+	}
+
+	return strings.Compare(a.v.(string), b.v.(string))
+}
+
+// comparePointers orders values that have nothing else to be told apart by
+// where they live, which is arbitrary but does not change while they do.
+func comparePointers(a interface{}, b interface{}) int {
+	if reflect.ValueOf(a).Pointer() < reflect.ValueOf(b).Pointer() {
+		return -1
+	}
+	return 1
+}
+
+func compareNumbers(a *Value, b *Value) int {
+	switch aInt, bInt := a.Type() == ValueTypeInt, b.Type() == ValueTypeInt; {
+	case aInt && bInt:
+		return compareInts(a.Int(), b.Int())
+	case aInt:
+		return compareIntFloat(a.Int(), b.Float())
+	case bInt:
+		return -compareIntFloat(b.Int(), a.Float())
+	}
+
+	x, y := a.Float(), b.Float()
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	case x == y:
+		return 0
+	}
+
+	// NaN is not ordered with respect to any number, so it is put before all
+	// of them.
+	switch xNaN, yNaN := math.IsNaN(x), math.IsNaN(y); {
+	case xNaN && yNaN:
+		return 0
+	case xNaN:
+		return -1
+	}
+	return 1
+}
+
+func compareInts(x int64, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compareIntFloat compares an integer with a float exactly, rather than
+// converting the integer to a float, which loses precision above 2^53.
+func compareIntFloat(x int64, y float64) int {
+	switch {
+	case math.IsNaN(y):
+		return 1
+	case y >= 1<<63:
+		return -1
+	case y < -(1 << 63):
+		return 1
+	}
+	t := math.Trunc(y)
+	if c := compareInts(x, int64(t)); c != 0 {
+		return c
+	}
+	switch {
+	case y > t:
+		return -1
+	case y < t:
+		return 1
+	}
+	return 0
+}
+
+func compareLists(x []*Value, y []*Value) int {
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if c := Compare(x[i], y[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(x) < len(y):
+		return -1
+	case len(x) > len(y):
+		return 1
+	}
+	return 0
+}
+
+// compareMaps orders maps by their entries, taken in the order of their keys,
+// comparing the key of each entry first and then its value.
+func compareMaps(x *Map, y *Map) int {
+	xKeys, yKeys := x.Keys(), y.Keys()
+	for i := 0; i < len(xKeys) && i < len(yKeys); i++ {
+		if c := Compare(xKeys[i], yKeys[i]); c != 0 {
+			return c
+		}
+		xValue, _ := x.Get(xKeys[i])
+		yValue, _ := y.Get(yKeys[i])
+		if c := Compare(xValue, yValue); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(xKeys) < len(yKeys):
+		return -1
+	case len(xKeys) > len(yKeys):
+		return 1
+	}
+	return 0
+}
+
+// sameFunction reports whether a and b are the same function, either because
+// they are the same value, because they were made from the same definition or
+// because they stand for equal expressions.
+func sameFunction(a *Value, b *Value) bool {
+	if a == b {
+		return true
+	}
+	if a.lambda != nil || b.lambda != nil {
+		return a.lambda == b.lambda
+	}
+	if a.expr != nil && b.expr != nil {
+		return compareLists(a.expr.values, b.expr.values) == 0
+	}
+	return false
+}
@@ -1,14 +1,35 @@
 package context
 
 import (
+	gocontext "context"
 	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
+
+	"github.com/xiam/sexpr/ast"
 )
 
 var ctxID = uint64(0)
 
+// Context is a step of an evaluation. Values flow into a context as the
+// arguments of the function running on it and flow out as its results, and
+// each end of those flows belongs to a single goroutine:
+//
+//   - A feeder goroutine pushes the arguments, calling Accept and then Push
+//     for each of them, and Close once it has no more. Close may be called
+//     from anywhere, but only the feeder calls Accept and Push.
+//   - The function running on the context reads its arguments with Next,
+//     Argument and Arguments, and yields its results with Yield and Return.
+//     Values must not be yielded on a context from any other goroutine.
+//   - The goroutine that runs the function calls Exit once the function
+//     returns, usually with defer, which closes the results. Nothing is
+//     yielded on a context after Exit.
+//   - A single reader takes the results with Output, Collect, Results or
+//     Result.
+//
+// The settings of a context, like its name, trap or Go context, are set
+// before it is handed to other goroutines and not changed afterwards.
 type Context struct {
 	id   uint64
 	name string
@@ -16,12 +37,15 @@ type Context struct {
 	Parent *Context
 
 	executable bool
+	tail       bool
 
 	ticket chan struct{}
 
-	inMu  sync.Mutex
+	// mu guards inClosed and the closing of in, while outMu guards
+	// outClosed. Readers that only need to know whether the input is closed
+	// check doneAccept instead.
 	mu    sync.Mutex
-	argMu sync.Mutex
+	outMu sync.Mutex
 
 	in       chan *Value
 	inClosed bool
@@ -36,13 +60,159 @@ type Context struct {
 	exitStatus   error
 	lastArgument *Value
 
+	goctx gocontext.Context
+	fuel  *Fuel
+	mem   *Memory
+	trap  *Trap
+
+	node  *ast.Node
+	frame *Frame
+
 	st *symbolTable
 }
 
+// WithContext binds ctx to the given Go context. Once goctx is cancelled or
+// its deadline passes every blocking operation on ctx and on the contexts
+// derived from it gives up and Err reports the cancellation.
+func (ctx *Context) WithContext(goctx gocontext.Context) *Context {
+	ctx.goctx = goctx
+	return ctx
+}
+
+// Context returns the Go context ctx is bound to.
+func (ctx *Context) Context() gocontext.Context {
+	return ctx.goctx
+}
+
+// Err returns a cancellation error if the Go context bound to ctx is done.
+func (ctx *Context) Err() error {
+	if err := ctx.goctx.Err(); err != nil {
+		return canceledError{cause: err}
+	}
+	return nil
+}
+
+// WithFuel makes ctx and the contexts derived from it take their steps from
+// the given budget.
+func (ctx *Context) WithFuel(fuel *Fuel) *Context {
+	ctx.fuel = fuel
+	return ctx
+}
+
+// Fuel returns the budget ctx takes its steps from, if any.
+func (ctx *Context) Fuel() *Fuel {
+	return ctx.fuel
+}
+
+// Step consumes one step from the budget bound to ctx.
+func (ctx *Context) Step() error {
+	if ctx.fuel == nil {
+		return nil
+	}
+	return ctx.fuel.Consume(1)
+}
+
+// WithMemory makes ctx and the contexts derived from it account the values
+// they allocate on the given memory.
+func (ctx *Context) WithMemory(mem *Memory) *Context {
+	ctx.mem = mem
+	return ctx
+}
+
+// Memory returns the accountant ctx allocates values on, if any.
+func (ctx *Context) Memory() *Memory {
+	return ctx.mem
+}
+
+// Alloc accounts value against the memory limits bound to ctx and returns
+// it. Every value yielded is accounted as well, so Alloc is only needed to
+// fail before handing out a value, like the items of a list being built, or
+// for values that are kept without being yielded.
+func (ctx *Context) Alloc(value *Value) (*Value, error) {
+	if ctx.mem == nil {
+		return value, nil
+	}
+	if err := ctx.mem.Alloc(value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// WithTrap makes ctx and the contexts derived from it record their runtime
+// errors on the given trap.
+func (ctx *Context) WithTrap(trap *Trap) *Context {
+	ctx.trap = trap
+	return ctx
+}
+
+// Trap returns the trap ctx records its runtime errors on, if any.
+func (ctx *Context) Trap() *Trap {
+	return ctx.trap
+}
+
+// Frame is a call to a fn function.
+type Frame struct {
+	Name string
+	// Node is the expression the function was called from.
+	Node *ast.Node
+}
+
+// At records n as the expression being evaluated on ctx.
+func (ctx *Context) At(n *ast.Node) *Context {
+	ctx.node = n
+	return ctx
+}
+
+// Node returns the expression being evaluated on ctx or on the closest of its
+// parents.
+func (ctx *Context) Node() *ast.Node {
+	for c := ctx; c != nil; c = c.Parent {
+		if c.node != nil {
+			return c.node
+		}
+	}
+	return nil
+}
+
+// Enter marks ctx as the frame of a call to the named function.
+func (ctx *Context) Enter(name string) *Context {
+	ctx.frame = &Frame{Name: name, Node: ctx.Node()}
+	return ctx
+}
+
+// Stack returns the frames ctx is running within, starting with the
+// innermost one.
+func (ctx *Context) Stack() []Frame {
+	frames := []Frame{}
+	for c := ctx; c != nil; c = c.Parent {
+		if c.frame != nil {
+			frames = append(frames, *c.frame)
+		}
+	}
+	return frames
+}
+
+func (ctx *Context) done() <-chan struct{} {
+	return ctx.goctx.Done()
+}
+
+// Closed reports whether ctx exited and takes no more results.
 func (ctx *Context) Closed() bool {
+	ctx.outMu.Lock()
+	defer ctx.outMu.Unlock()
 	return ctx.outClosed
 }
 
+// inputClosed reports whether the input of ctx was closed.
+func (ctx *Context) inputClosed() bool {
+	select {
+	case <-ctx.doneAccept:
+		return true
+	default:
+		return false
+	}
+}
+
 func (ctx *Context) Name(name string) *Context {
 	ctx.name = name
 	return ctx
@@ -62,15 +232,17 @@ func (ctx *Context) Executable() *Context {
 	return ctx
 }
 
-func (ctx *Context) closeIn() {
-	if ctx.inClosed {
-		return
-	}
-
-	close(ctx.accept)
-	close(ctx.in)
+// Tail marks ctx as being in tail position, the value it yields is the
+// result of the function it belongs to. Contexts derived from ctx are not in
+// tail position.
+func (ctx *Context) Tail() *Context {
+	ctx.tail = true
+	return ctx
+}
 
-	ctx.inClosed = true
+// IsTail reports whether ctx is in tail position.
+func (ctx *Context) IsTail() bool {
+	return ctx.tail
 }
 
 func (ctx *Context) exit(err error) error {
@@ -81,24 +253,33 @@ func (ctx *Context) exit(err error) error {
 }
 
 func (ctx *Context) Next() bool {
-	ctx.mu.Lock()
-	if ctx.inClosed {
-		ctx.mu.Unlock()
+	if ctx.inputClosed() {
+		return false
+	}
+	// accept is never closed, so asking for the next argument is safe even
+	// if the feeder closes the input in the meantime.
+	select {
+	case ctx.accept <- struct{}{}:
+	case <-ctx.doneAccept:
+		return false
+	case <-ctx.done():
 		return false
 	}
-	ctx.accept <- struct{}{}
-	ctx.mu.Unlock()
 
-	var ok bool
-	ctx.lastArgument, ok = <-ctx.in
-	if !ok {
+	select {
+	case value, ok := <-ctx.in:
+		if !ok {
+			return false
+		}
+		ctx.lastArgument = value
+		return true
+	case <-ctx.done():
 		return false
 	}
-	return true
 }
 
 func (ctx *Context) Arguments() ([]*Value, error) {
-	if ctx.inClosed {
+	if ctx.inputClosed() {
 		return nil, ErrStreamClosed
 	}
 	args := []*Value{}
@@ -120,12 +301,15 @@ func (ctx *Context) Argument() (*Value, error) {
 }
 
 func (ctx *Context) Exit(err error) {
+	ctx.outMu.Lock()
 	if ctx.outClosed {
+		ctx.outMu.Unlock()
 		return
 	}
-
-	close(ctx.out)
 	ctx.outClosed = true
+	close(ctx.out)
+	ctx.outMu.Unlock()
+
 	ctx.Close()
 }
 
@@ -137,7 +321,6 @@ func (ctx *Context) Close() {
 	}
 	ctx.inClosed = true
 	close(ctx.doneAccept)
-	close(ctx.accept)
 	close(ctx.in)
 }
 
@@ -147,7 +330,11 @@ func (ctx *Context) Push(value *Value) error {
 	if ctx.inClosed {
 		return errors.New("channel is closed")
 	}
-	ctx.in <- value
+	select {
+	case ctx.in <- value:
+	case <-ctx.done():
+		return ctx.Err()
+	}
 	return nil
 }
 
@@ -162,11 +349,12 @@ func (ctx *Context) Return(values ...*Value) error {
 }
 
 func (ctx *Context) Accept() bool {
-	if ctx.inClosed {
+	if ctx.inputClosed() {
 		return false
 	}
 	select {
 	case <-ctx.doneAccept:
+	case <-ctx.done():
 	case <-ctx.accept:
 		return true
 	}
@@ -183,22 +371,33 @@ func (ctx *Context) Yield(values ...*Value) error {
 }
 
 func (ctx *Context) yield(value *Value) error {
-	if ctx.outClosed {
+	if ctx.Closed() {
 		return nil
 	}
 	if value == nil {
 		panic("can't yield nil value")
 	}
-	ctx.out <- value
+	if _, err := ctx.Alloc(value); err != nil {
+		return err
+	}
+	select {
+	case ctx.out <- value:
+	case <-ctx.done():
+		return ctx.Err()
+	}
 	return nil
 }
 
 func (ctx *Context) Output() (*Value, error) {
-	out, ok := <-ctx.out
-	if !ok {
-		return nil, ErrClosedChannel
+	select {
+	case out, ok := <-ctx.out:
+		if !ok {
+			return nil, ErrClosedChannel
+		}
+		return out, nil
+	case <-ctx.done():
+		return nil, ctx.Err()
 	}
-	return out, nil
 }
 
 func (ctx *Context) Results() (*Value, error) {
@@ -228,8 +427,11 @@ func (ctx *Context) Collect() ([]*Value, error) {
 
 	for {
 		value, err := ctx.Output()
-		if err == ErrClosedChannel {
-			break
+		if err != nil {
+			if err == ErrClosedChannel {
+				break
+			}
+			return nil, err
 		}
 		values = append(values, value)
 	}
@@ -259,6 +461,32 @@ func (ctx *Context) Get(name string) (*Value, error) {
 	return value, nil
 }
 
+// SetNamespace makes the bindings of ns available on ctx under the given
+// name, so that name/symbol refers to symbol as bound on ns.
+func (ctx *Context) SetNamespace(name string, ns *Context) error {
+	if !ctx.executable {
+		return errors.New("cannot set on a non-executable context")
+	}
+	return ctx.st.SetDict(name, ns.st)
+}
+
+// Import binds on ctx every value bound on ns but the ones named in except.
+func (ctx *Context) Import(ns *Context, except ...string) error {
+	skip := map[string]bool{}
+	for _, name := range except {
+		skip[name] = true
+	}
+	for name, value := range ns.st.Values() {
+		if skip[name] {
+			continue
+		}
+		if err := ctx.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func NewClosure(parent *Context) *Context {
 	ctx := New(parent)
 	if parent != nil {
@@ -281,38 +509,80 @@ func New(parent *Context) *Context {
 	if parent == nil {
 		ctx.st = newSymbolTable(nil)
 		ctx.executable = true
+		ctx.goctx = gocontext.Background()
 	} else {
 		ctx.Parent = parent
 		ctx.executable = parent.executable
+		ctx.goctx = parent.goctx
+		ctx.fuel = parent.fuel
+		ctx.mem = parent.mem
+		ctx.trap = parent.trap
 		ctx.st = newSymbolTable(parent.st)
 	}
 	return ctx
 }
 
 func ExecArgument(ctx *Context, value *Value) (*Value, error) {
+	return execArgument(ctx, value, false)
+}
+
+// ExecTailArgument is like ExecArgument but evaluates value in tail position
+// if ctx is in tail position. It is meant for functions whose result is the
+// value of that argument.
+func ExecTailArgument(ctx *Context, value *Value) (*Value, error) {
+	return execArgument(ctx, value, ctx.tail)
+}
+
+func execArgument(ctx *Context, value *Value, tail bool) (*Value, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	switch value.Type() {
 	case ValueTypeInt:
 		return value, nil
 	case ValueTypeList:
-		var err error
-		m := value.List()
-		for k := range m {
-			m[k], err = ExecArgument(ctx, m[k])
+		// Lists and maps are shared by everyone holding them, so their items
+		// are evaluated into a new list or map, and value itself is returned
+		// when none of them changed.
+		items := value.List()
+		var list []*Value
+		for i := range items {
+			item, err := ExecArgument(ctx, items[i])
 			if err != nil {
 				return nil, err
 			}
+			if list == nil && item != items[i] {
+				list = make([]*Value, len(items))
+				copy(list, items[:i])
+			}
+			if list != nil {
+				list[i] = item
+			}
 		}
-		return value, nil
+		if list == nil {
+			return value, nil
+		}
+		return ctx.Alloc(NewListValue(list))
 	case ValueTypeMap:
-		var err error
 		m := value.Map()
-		for k := range m {
-			m[k], err = ExecArgument(ctx, m[k])
+		var result *Map
+		for _, k := range m.Keys() {
+			v, _ := m.Get(k)
+			item, err := ExecArgument(ctx, v)
 			if err != nil {
 				return nil, err
 			}
+			if result == nil && item != v {
+				result = m.Copy()
+			}
+			if result != nil {
+				result.Set(k, item)
+			}
 		}
-		return value, nil
+		if result == nil {
+			return value, nil
+		}
+		return ctx.Alloc(NewMapValue(result))
 	case ValueTypeSymbol:
 		v, err := ctx.Get(value.String())
 		if err != nil {
@@ -321,14 +591,23 @@ func ExecArgument(ctx *Context, value *Value) (*Value, error) {
 		return v, nil
 	case ValueTypeFunction:
 		newCtx := New(ctx).Name("argument")
+		newCtx.tail = tail
+		fnErr := make(chan error, 1)
 		go func() {
 			defer newCtx.Exit(nil)
-			if err := value.Function().Exec(newCtx); err != nil {
-				panic(err.Error())
-			}
+			fnErr <- value.Function().Exec(newCtx)
 		}()
 		col, err := newCtx.Results()
-		return col.List()[0], err
+		if err != nil {
+			return nil, err
+		}
+		if err := <-fnErr; err != nil {
+			return nil, err
+		}
+		if len(col.List()) < 1 {
+			return Nil, nil
+		}
+		return col.List()[0], nil
 	}
 	return value, nil
 }
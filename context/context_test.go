@@ -1,6 +1,10 @@
 package context
 
 import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"math"
 	"sync"
 	"testing"
 
@@ -370,3 +374,334 @@ func TestContextReturn(t *testing.T) {
 		wg.Wait()
 	}
 }
+
+func TestContextCancel(t *testing.T) {
+	goctx, cancel := gocontext.WithCancel(gocontext.Background())
+
+	ctx := New(nil).WithContext(goctx)
+	assert.NoError(t, ctx.Err())
+
+	child := New(ctx)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		err := child.Yield(True)
+		assert.NoError(t, err)
+
+		<-goctx.Done()
+
+		err = child.Yield(False)
+		assert.True(t, errors.Is(err, ErrCanceled))
+
+		assert.False(t, child.Next())
+	}()
+
+	value, err := child.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, True, value)
+
+	cancel()
+	wg.Wait()
+
+	_, err = child.Collect()
+	assert.True(t, errors.Is(err, ErrCanceled))
+	assert.True(t, errors.Is(err, gocontext.Canceled))
+}
+
+func TestMapStructuralKeys(t *testing.T) {
+	m := NewMap()
+
+	list := NewListValue([]*Value{NewIntValue(1), NewIntValue(2)})
+	m.Set(list, NewAtomValue(":a"))
+	m.Set(NewStringValue("x"), NewIntValue(1))
+
+	{
+		v, ok := m.Get(NewListValue([]*Value{NewIntValue(1), NewIntValue(2)}))
+		assert.True(t, ok)
+		assert.Equal(t, ":a", v.String())
+	}
+
+	{
+		_, ok := m.Get(NewListValue([]*Value{NewIntValue(2), NewIntValue(1)}))
+		assert.False(t, ok)
+	}
+
+	{
+		v, ok := m.Get(NewStringValue("x"))
+		assert.True(t, ok)
+		assert.Equal(t, "1", v.String())
+	}
+
+	c := m.Copy()
+	c.Delete(list)
+	assert.Equal(t, 2, m.Len())
+	assert.Equal(t, 1, c.Len())
+
+	a, b := NewMap(), NewMap()
+	a.Set(NewAtomValue(":a"), NewIntValue(1))
+	a.Set(NewAtomValue(":b"), NewIntValue(2))
+	b.Set(NewAtomValue(":b"), NewIntValue(2))
+	b.Set(NewAtomValue(":a"), NewIntValue(1))
+	assert.Equal(t, Hash(NewMapValue(a)), Hash(NewMapValue(b)))
+
+	// Expressions are keyed by their forms, which are compared the same way
+	// as any other value.
+	m.Set(NewExpressionValue([]*Value{NewSymbolValue("f"), NewIntValue(1)}, nil), NewAtomValue(":expr"))
+	{
+		v, ok := m.Get(NewExpressionValue([]*Value{NewSymbolValue("f"), NewFloatValue(1)}, nil))
+		assert.True(t, ok)
+		assert.Equal(t, ":expr", v.String())
+	}
+
+	m.Set(NewFloatValue(math.NaN()), NewAtomValue(":nan"))
+	{
+		v, ok := m.Get(NewFloatValue(math.Float64frombits(0x7ff8000000000002)))
+		assert.True(t, ok)
+		assert.Equal(t, ":nan", v.String())
+	}
+}
+
+func TestEqualCompare(t *testing.T) {
+	list := func(values ...*Value) *Value {
+		return NewListValue(values)
+	}
+	dict := func(values ...*Value) *Value {
+		m := NewMap()
+		for i := 0; i < len(values); i += 2 {
+			m.Set(values[i], values[i+1])
+		}
+		return NewMapValue(m)
+	}
+
+	assert.True(t, Equal(NewIntValue(1), NewFloatValue(1.0)))
+	assert.False(t, Equal(NewIntValue(1), NewStringValue("1")))
+	assert.False(t, Equal(NewSymbolValue("a"), NewStringValue("a")))
+	assert.True(t, Equal(list(NewIntValue(1), list(NewFloatValue(2))), list(NewFloatValue(1), list(NewIntValue(2)))))
+	assert.False(t, Equal(list(NewIntValue(1)), list(NewIntValue(1), NewIntValue(2))))
+	assert.True(t, Equal(
+		dict(NewAtomValue(":a"), NewIntValue(1), NewAtomValue(":b"), list()),
+		dict(NewAtomValue(":b"), list(), NewAtomValue(":a"), NewFloatValue(1)),
+	))
+	assert.False(t, Equal(dict(NewAtomValue(":a"), NewIntValue(1)), dict(NewAtomValue(":a"), NewIntValue(2))))
+
+	// Integers and floats are compared exactly, even above 2^53 where not
+	// every integer has a float.
+	big, bigger := NewIntValue(1<<53), NewIntValue(1<<53+1)
+	assert.True(t, Equal(big, NewFloatValue(1<<53)))
+	assert.False(t, Equal(bigger, NewFloatValue(1<<53)))
+	assert.Equal(t, 1, Compare(bigger, NewFloatValue(1<<53)))
+	assert.Equal(t, -1, Compare(NewFloatValue(1<<53), bigger))
+	assert.Equal(t, -1, Compare(NewIntValue(math.MaxInt64), NewFloatValue(1<<63)))
+	assert.Equal(t, -1, Compare(NewIntValue(2), NewFloatValue(2.5)))
+	assert.Equal(t, 1, Compare(NewIntValue(-2), NewFloatValue(-2.5)))
+	assert.Equal(t, Hash(big), Hash(NewFloatValue(1<<53)))
+	assert.NotEqual(t, Hash(bigger), Hash(NewFloatValue(1<<53)))
+
+	fn := NewFunctionValue(func(*Context) error { return nil })
+	other := NewFunctionValue(func(*Context) error { return nil })
+	assert.True(t, Equal(fn, fn))
+	assert.False(t, Equal(fn, other))
+	assert.NotEqual(t, 0, Compare(fn, other))
+	assert.Equal(t, -Compare(fn, other), Compare(other, fn))
+
+	ordered := []*Value{
+		NewIntValue(-1),
+		NewFloatValue(0.5),
+		NewIntValue(2),
+		NewSymbolValue("a"),
+		NewAtomValue(":a"),
+		NewStringValue("a"),
+		NewStringValue("b"),
+		dict(NewAtomValue(":a"), NewIntValue(1)),
+		dict(NewAtomValue(":a"), NewIntValue(1), NewAtomValue(":b"), NewIntValue(1)),
+		list(),
+		list(NewIntValue(1)),
+		list(NewIntValue(1), NewIntValue(2)),
+		list(NewIntValue(2)),
+		fn,
+	}
+	for i := range ordered {
+		for j := range ordered {
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			assert.Equal(t, expected, Compare(ordered[i], ordered[j]), "%v <=> %v", ordered[i], ordered[j])
+		}
+	}
+
+	assert.Equal(t, Hash(NewIntValue(3)), Hash(NewFloatValue(3)))
+}
+
+func TestChanSelect(t *testing.T) {
+	ctx := New(nil)
+
+	a, b := NewChan(1), NewChan(0)
+
+	{
+		i, _, err := Select(ctx, []SelectCase{{Chan: a}, {Chan: b}}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, -1, i)
+	}
+
+	{
+		assert.NoError(t, a.Send(ctx, NewIntValue(1)))
+		i, value, err := Select(ctx, []SelectCase{{Chan: b}, {Chan: a}}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, i)
+		assert.Equal(t, "1", value.String())
+	}
+
+	{
+		assert.NoError(t, a.Send(ctx, NewIntValue(2)))
+		assert.NoError(t, a.Close())
+		assert.Error(t, a.Close())
+		assert.Equal(t, ErrChanClosed, a.Send(ctx, NewIntValue(3)))
+
+		value, ok, err := a.Recv(ctx)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "2", value.String())
+
+		value, ok, err = a.Recv(ctx)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, Nil, value)
+	}
+
+	{
+		goctx, cancel := gocontext.WithCancel(gocontext.Background())
+		cancel()
+		_, _, err := b.Recv(New(nil).WithContext(goctx))
+		assert.True(t, errors.Is(err, ErrCanceled))
+	}
+}
+
+func TestRef(t *testing.T) {
+	r := NewRef(NewIntValue(0))
+
+	var mu sync.Mutex
+	changes := 0
+	r.Watch(NewAtomValue(":count"), func(ctx *Context, key *Value, old *Value, new *Value) error {
+		mu.Lock()
+		changes++
+		mu.Unlock()
+		return nil
+	})
+
+	ctx := New(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Swap(ctx, func(old *Value) (*Value, error) {
+				return NewIntValue(old.Int() + 1), nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, "100", r.Deref().String())
+	assert.Equal(t, 100, changes)
+
+	ok, err := r.CompareAndSet(ctx, NewIntValue(99), NewIntValue(0))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = r.CompareAndSet(ctx, NewFloatValue(100), NewIntValue(0))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	old, err := r.Reset(ctx, NewIntValue(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "0", old.String())
+
+	assert.True(t, r.Unwatch(NewAtomValue(":count")))
+	assert.False(t, r.Unwatch(NewAtomValue(":count")))
+	_, err = r.Reset(ctx, NewIntValue(2))
+	assert.NoError(t, err)
+	assert.Equal(t, 102, changes)
+}
+
+func TestConcurrentSymbolTable(t *testing.T) {
+	ctx := New(nil)
+	scope := New(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, ctx.Set(fmt.Sprintf("x%d", i), NewIntValue(int64(i))))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, _ = scope.Get("x0")
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		value, err := scope.Get(fmt.Sprintf("x%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%d", i), value.String())
+	}
+}
+
+func TestExecArgumentShared(t *testing.T) {
+	list := NewListValue([]*Value{NewSymbolValue("x"), NewIntValue(1)})
+
+	m := NewMap()
+	m.Set(NewAtomValue(":x"), NewSymbolValue("x"))
+	dict := NewMapValue(m)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx := New(nil)
+			assert.NoError(t, ctx.Set("x", NewIntValue(int64(i))))
+
+			value, err := ExecArgument(ctx, list)
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("[%d 1]", i), value.String())
+
+			value, err = ExecArgument(ctx, dict)
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("{:x %d}", i), value.String())
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, "[x 1]", list.String())
+	assert.Equal(t, "{:x x}", dict.String())
+}
+
+func TestMemoryShared(t *testing.T) {
+	a := NewMemory(Limits{MaxValues: 2})
+	b := NewMemory(Limits{MaxValues: 2})
+
+	// Values touched by other evaluations in between are still recorded only
+	// once on each of them.
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, a.Alloc(Nil))
+		assert.NoError(t, b.Alloc(Nil))
+		assert.NoError(t, a.Alloc(True))
+		assert.NoError(t, b.Alloc(True))
+	}
+	assert.Equal(t, int64(2), a.Allocated())
+	assert.Equal(t, int64(2), b.Allocated())
+
+	err := a.Alloc(False)
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+	assert.NoError(t, b.Err())
+}
@@ -2,6 +2,9 @@ package context
 
 import (
 	"errors"
+	"fmt"
+
+	"github.com/xiam/sexpr/ast"
 )
 
 var (
@@ -13,4 +16,43 @@ var (
 	ErrUndefinedValue    = errors.New("undefined value")
 	ErrUndefinedFunction = errors.New("undefined function")
 	ErrClosedChannel     = errors.New("closed channel")
+	ErrOutOfFuel         = errors.New("out of fuel")
+	ErrLimitExceeded     = errors.New("memory limit exceeded")
 )
+
+// ErrCanceled is matched by the errors returned when an evaluation is stopped
+// by its Go context.
+var ErrCanceled = errors.New("evaluation canceled")
+
+type canceledError struct {
+	cause error
+}
+
+func (e canceledError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrCanceled, e.cause)
+}
+
+func (e canceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+func (e canceledError) Unwrap() error {
+	return e.cause
+}
+
+// TailCall is returned by a function call in tail position to have the
+// enclosing function call replaced by a call to Fn with the given arguments.
+// A nil Fn stands for the innermost loop or function (recur).
+type TailCall struct {
+	Fn   *Value
+	Args []*Value
+	// Node is the expression the call was made from.
+	Node *ast.Node
+}
+
+func (tc *TailCall) Error() string {
+	if tc.Fn == nil {
+		return "recur outside of loop or function"
+	}
+	return "tail call outside of function"
+}
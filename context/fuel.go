@@ -0,0 +1,56 @@
+package context
+
+import (
+	"sync/atomic"
+)
+
+// Fuel counts the steps taken by an evaluation and, when it has a limit,
+// stops the evaluation once the limit is reached.
+type Fuel struct {
+	limit int64
+	used  int64
+}
+
+// NewFuel creates a budget of limit steps. A limit of zero means the budget
+// is unbounded and the steps are only counted.
+func NewFuel(limit int64) *Fuel {
+	return &Fuel{limit: limit}
+}
+
+// Consume takes n steps from the budget, it fails with ErrOutOfFuel once
+// there are no steps left.
+func (f *Fuel) Consume(n int64) error {
+	used := atomic.AddInt64(&f.used, n)
+	if f.limit > 0 && used > f.limit {
+		return ErrOutOfFuel
+	}
+	return nil
+}
+
+// Limit returns the size of the budget.
+func (f *Fuel) Limit() int64 {
+	return f.limit
+}
+
+// Used returns the number of steps taken so far.
+func (f *Fuel) Used() int64 {
+	used := atomic.LoadInt64(&f.used)
+	if f.limit > 0 && used > f.limit {
+		return f.limit
+	}
+	return used
+}
+
+// Exhausted reports whether a step was refused because the budget ran out.
+func (f *Fuel) Exhausted() bool {
+	return f.limit > 0 && atomic.LoadInt64(&f.used) > f.limit
+}
+
+// Remaining returns the number of steps left, or -1 if the budget is
+// unbounded.
+func (f *Fuel) Remaining() int64 {
+	if f.limit == 0 {
+		return -1
+	}
+	return f.limit - f.Used()
+}
@@ -0,0 +1,79 @@
+package context
+
+import (
+	"sync"
+)
+
+// Future is the result of a computation that may not be done yet.
+type Future struct {
+	done chan struct{}
+	once sync.Once
+
+	value *Value
+	err   error
+
+	runMu sync.Mutex
+	run   func() (*Value, error)
+}
+
+// NewFuture creates a future that is done once it is resolved.
+func NewFuture() *Future {
+	return &Future{
+		done: make(chan struct{}),
+	}
+}
+
+// NewTask creates a future that is resolved with the result of run. run is
+// called by the first one to call Run.
+func NewTask(run func() (*Value, error)) *Future {
+	f := NewFuture()
+	f.run = run
+	return f
+}
+
+// Resolve sets the result of f and wakes up everyone waiting for it. Only the
+// first call has an effect, Resolve reports whether it was that one.
+func (f *Future) Resolve(value *Value, err error) bool {
+	resolved := false
+	f.once.Do(func() {
+		f.value, f.err = value, err
+		close(f.done)
+		resolved = true
+	})
+	return resolved
+}
+
+// Run runs the task of f and resolves f with its result, unless f is not a
+// task or its task was already taken.
+func (f *Future) Run() {
+	f.runMu.Lock()
+	run := f.run
+	f.run = nil
+	f.runMu.Unlock()
+
+	if run != nil {
+		f.Resolve(run())
+	}
+}
+
+// Pending reports whether f is a task that nobody started running yet.
+func (f *Future) Pending() bool {
+	f.runMu.Lock()
+	defer f.runMu.Unlock()
+	return f.run != nil
+}
+
+// Done returns a channel that is closed once f is resolved.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until f is resolved and returns its result.
+func (f *Future) Wait(ctx *Context) (*Value, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.done():
+		return nil, ctx.Err()
+	}
+}
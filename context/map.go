@@ -0,0 +1,163 @@
+package context
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+type mapEntry struct {
+	key   *Value
+	value *Value
+}
+
+// Map is a hash map keyed by the structure of values, any value can be a key
+// and two keys are the same if they are Equal. Maps held by values must not be
+// changed once the value is built, use Copy to derive new maps instead.
+type Map struct {
+	buckets map[uint64][]mapEntry
+	size    int
+}
+
+// NewMap creates an empty map.
+func NewMap() *Map {
+	return &Map{
+		buckets: map[uint64][]mapEntry{},
+	}
+}
+
+// Len returns the number of entries in m.
+func (m *Map) Len() int {
+	return m.size
+}
+
+// Get returns the value stored under key.
+func (m *Map) Get(key *Value) (*Value, bool) {
+	for _, entry := range m.buckets[Hash(key)] {
+		if Equal(entry.key, key) {
+			return entry.value, true
+		}
+	}
+	return nil, false
+}
+
+// Set stores value under key, replacing the value that was there, if any.
+func (m *Map) Set(key *Value, value *Value) {
+	h := Hash(key)
+	bucket := m.buckets[h]
+	for i := range bucket {
+		if Equal(bucket[i].key, key) {
+			bucket[i].value = value
+			return
+		}
+	}
+	m.buckets[h] = append(bucket, mapEntry{key: key, value: value})
+	m.size++
+}
+
+// Delete removes the entry stored under key, if any.
+func (m *Map) Delete(key *Value) {
+	h := Hash(key)
+	bucket := m.buckets[h]
+	for i := range bucket {
+		if Equal(bucket[i].key, key) {
+			bucket = append(bucket[:i:i], bucket[i+1:]...)
+			if len(bucket) == 0 {
+				delete(m.buckets, h)
+			} else {
+				m.buckets[h] = bucket
+			}
+			m.size--
+			return
+		}
+	}
+}
+
+// Keys returns the keys of m in the order given by Compare.
+func (m *Map) Keys() []*Value {
+	keys := make([]*Value, 0, m.size)
+	for _, bucket := range m.buckets {
+		for _, entry := range bucket {
+			keys = append(keys, entry.key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return Compare(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// Copy returns a new map with the same entries as m.
+func (m *Map) Copy() *Map {
+	c := &Map{
+		buckets: make(map[uint64][]mapEntry, len(m.buckets)),
+		size:    m.size,
+	}
+	for h, bucket := range m.buckets {
+		c.buckets[h] = append([]mapEntry{}, bucket...)
+	}
+	return c
+}
+
+// Hash returns a hash of the structure of value, values that are Equal have
+// the same hash. As Equal holds every NaN equal to any other, they all share a
+// hash too, so a NaN key is found again by any NaN.
+func Hash(value *Value) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+
+	writeUint64 := func(n uint64) {
+		binary.LittleEndian.PutUint64(buf, n)
+		h.Write(buf)
+	}
+
+	switch value.Type() {
+	case ValueTypeInt, ValueTypeFloat:
+		// Integers and floats share a hash when they hold the same number,
+		// so floats that hold an integer are hashed as that integer.
+		h.Write([]byte{byte(ValueTypeFloat)})
+		if value.Type() == ValueTypeInt {
+			writeUint64(uint64(value.Int()))
+		} else if f := value.Float(); f == math.Trunc(f) && f >= -(1<<63) && f < 1<<63 {
+			writeUint64(uint64(int64(f)))
+		} else if math.IsNaN(f) {
+			writeUint64(math.Float64bits(math.NaN()))
+		} else {
+			writeUint64(math.Float64bits(f))
+		}
+		return h.Sum64()
+	}
+
+	h.Write([]byte{byte(value.Type())})
+
+	switch value.Type() {
+	case ValueTypeList:
+		for _, item := range value.List() {
+			writeUint64(Hash(item))
+		}
+	case ValueTypeMap:
+		// Entries are combined in a way that does not depend on their order.
+		var sum uint64
+		for _, bucket := range value.Map().buckets {
+			for _, entry := range bucket {
+				sum += Hash(entry.key)*31 + Hash(entry.value)
+			}
+		}
+		writeUint64(sum)
+	case ValueTypeFunction:
+		// Functions are only equal to themselves, except for expressions
+		// which are equal when their forms are.
+		if value.lambda == nil && value.expr != nil {
+			for _, form := range value.expr.values {
+				writeUint64(Hash(form))
+			}
+		}
+	case ValueTypeSeq, ValueTypeChan, ValueTypeFuture, ValueTypeRef:
+		// These are only equal to themselves, the type is all they share.
+	default:
+		h.Write([]byte(value.String()))
+	}
+
+	return h.Sum64()
+}
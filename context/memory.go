@@ -0,0 +1,123 @@
+package context
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Limits bounds the memory a single evaluation may use. A zero value in any
+// of the fields disables that limit.
+type Limits struct {
+	// MaxValues is the total number of values that can be allocated.
+	MaxValues int64
+	// MaxListLength is the maximum number of items in a list.
+	MaxListLength int
+	// MaxMapEntries is the maximum number of entries in a map.
+	MaxMapEntries int
+	// MaxStringLength is the maximum number of characters in a string.
+	MaxStringLength int
+}
+
+// Memory accounts the values allocated by an evaluation against its limits.
+type Memory struct {
+	limits    Limits
+	allocated int64
+
+	mu  sync.Mutex
+	err error
+	// seen holds the values recorded so far, so that values shared with
+	// other evaluations, like the ones bound on the root context, are
+	// recorded only once no matter who else touches them.
+	seen map[*Value]struct{}
+}
+
+// NewMemory creates an accountant for the given limits.
+func NewMemory(limits Limits) *Memory {
+	m := &Memory{limits: limits}
+	if limits.MaxValues > 0 {
+		m.seen = map[*Value]struct{}{}
+	}
+	return m
+}
+
+// Limits returns the limits m accounts values against.
+func (m *Memory) Limits() Limits {
+	return m.limits
+}
+
+// Allocated returns the number of values allocated so far. Without a
+// MaxValues limit values are not remembered, and the ones recorded more than
+// once are counted every time.
+func (m *Memory) Allocated() int64 {
+	return atomic.LoadInt64(&m.allocated)
+}
+
+// Alloc records the allocation of value, it fails with an error matching
+// ErrLimitExceeded if value is larger than allowed or if there is no room
+// left for more values. When m bounds the number of values, the ones already
+// recorded on m are not recorded again.
+func (m *Memory) Alloc(value *Value) error {
+	if m.seen != nil {
+		m.mu.Lock()
+		_, ok := m.seen[value]
+		if !ok && m.err == nil {
+			// Once a limit is exceeded the evaluation is over, so values are
+			// no longer remembered and seen never outgrows MaxValues.
+			m.seen[value] = struct{}{}
+		}
+		m.mu.Unlock()
+		if ok {
+			return nil
+		}
+	}
+	if err := m.check(value); err != nil {
+		return m.fail(err)
+	}
+	return m.take(1)
+}
+
+// take records n more values, it fails once there is no room left for them.
+func (m *Memory) take(n int64) error {
+	if total := atomic.AddInt64(&m.allocated, n); m.limits.MaxValues > 0 && total > m.limits.MaxValues {
+		return m.fail(fmt.Errorf("%w: more than %d values allocated", ErrLimitExceeded, m.limits.MaxValues))
+	}
+	return nil
+}
+
+// Err returns the first error reported by Alloc, if any.
+func (m *Memory) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *Memory) fail(err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+	return err
+}
+
+func (m *Memory) check(value *Value) error {
+	switch value.Type() {
+	case ValueTypeList:
+		if max := m.limits.MaxListLength; max > 0 && len(value.List()) > max {
+			return fmt.Errorf("%w: list of %d items is longer than %d", ErrLimitExceeded, len(value.List()), max)
+		}
+	case ValueTypeMap:
+		if max := m.limits.MaxMapEntries; max > 0 && value.Map().Len() > max {
+			return fmt.Errorf("%w: map of %d entries is larger than %d", ErrLimitExceeded, value.Map().Len(), max)
+		}
+	case ValueTypeString:
+		if max := m.limits.MaxStringLength; max > 0 {
+			if n := utf8.RuneCountInString(value.v.(string)); n > max {
+				return fmt.Errorf("%w: string of %d characters is longer than %d", ErrLimitExceeded, n, max)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package context
+
+import (
+	"sync"
+)
+
+// Watcher is called after the value of a reference changes, on the context
+// that changed it, with the key it was added under and the values before and
+// after the change.
+type Watcher func(ctx *Context, key *Value, old *Value, new *Value) error
+
+type watch struct {
+	key *Value
+	fn  Watcher
+}
+
+// Ref is a reference to a value that can be read and replaced from several
+// goroutines at once. Every change replaces the whole value, which is never
+// changed in place, so whoever reads a value can keep using it while others
+// replace it.
+type Ref struct {
+	mu      sync.Mutex
+	value   *Value
+	watches []watch
+}
+
+// NewRef creates a reference that holds value.
+func NewRef(value *Value) *Ref {
+	return &Ref{value: value}
+}
+
+// Deref returns the current value of r.
+func (r *Ref) Deref() *Value {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.value
+}
+
+// Reset replaces the value of r with value and returns the one it held.
+//
+// Reset, CompareAndSet and Swap call the watchers of r after the change is
+// made, and return the first error they return.
+func (r *Ref) Reset(ctx *Context, value *Value) (*Value, error) {
+	r.mu.Lock()
+	old := r.value
+	r.value = value
+	watches := r.watches
+	r.mu.Unlock()
+
+	return old, notify(ctx, watches, old, value)
+}
+
+// CompareAndSet replaces the value of r with value only if the value it holds
+// is Equal to old, and reports whether it did.
+func (r *Ref) CompareAndSet(ctx *Context, old *Value, value *Value) (bool, error) {
+	r.mu.Lock()
+	current := r.value
+	if !Equal(current, old) {
+		r.mu.Unlock()
+		return false, nil
+	}
+	r.value = value
+	watches := r.watches
+	r.mu.Unlock()
+
+	return true, notify(ctx, watches, current, value)
+}
+
+// Swap replaces the value of r with the result of calling fn on it and returns
+// the new value. fn is called without holding r, so it can read r or take as
+// long as it needs to, and is called again with the newer value if r changed
+// in the meantime.
+func (r *Ref) Swap(ctx *Context, fn func(old *Value) (*Value, error)) (*Value, error) {
+	for {
+		old := r.Deref()
+		value, err := fn(old)
+		if err != nil {
+			return nil, err
+		}
+
+		r.mu.Lock()
+		if r.value != old {
+			r.mu.Unlock()
+			continue
+		}
+		r.value = value
+		watches := r.watches
+		r.mu.Unlock()
+
+		return value, notify(ctx, watches, old, value)
+	}
+}
+
+// Watch calls fn after every change of r, replacing the watcher that was
+// added under an Equal key, if any.
+func (r *Ref) Watch(key *Value, fn Watcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The list is copied so that changes that are being notified keep the
+	// watchers they started with.
+	watches := make([]watch, 0, len(r.watches)+1)
+	for _, w := range r.watches {
+		if !Equal(w.key, key) {
+			watches = append(watches, w)
+		}
+	}
+	r.watches = append(watches, watch{key: key, fn: fn})
+}
+
+// Unwatch removes the watcher added under key and reports whether there was
+// one.
+func (r *Ref) Unwatch(key *Value) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	watches := make([]watch, 0, len(r.watches))
+	for _, w := range r.watches {
+		if !Equal(w.key, key) {
+			watches = append(watches, w)
+		}
+	}
+	removed := len(watches) < len(r.watches)
+	r.watches = watches
+	return removed
+}
+
+func notify(ctx *Context, watches []watch, old *Value, value *Value) error {
+	for _, w := range watches {
+		if err := w.fn(ctx, w.key, old, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
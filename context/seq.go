@@ -0,0 +1,105 @@
+package context
+
+import (
+	"sync"
+)
+
+// Seq is a lazy sequence. Its items are computed by calling next the first
+// time they are asked for and remembered afterwards, so a sequence can be
+// infinite as long as only a part of it is ever used.
+type Seq struct {
+	// mu guards the items computed so far, while turn is held by whoever is
+	// computing the next one.
+	mu    sync.Mutex
+	items []*Value
+	done  bool
+
+	turn chan struct{}
+	next func(ctx *Context) (*Value, bool, error)
+}
+
+// NewSeq creates a sequence whose items are returned by next, one per call,
+// until it reports there are no more items. next is given the context the
+// item is being asked for on and is never called concurrently.
+func NewSeq(next func(ctx *Context) (*Value, bool, error)) *Seq {
+	return &Seq{
+		turn: make(chan struct{}, 1),
+		next: next,
+	}
+}
+
+// Nth returns the item of s at index i, computing it and the ones before it
+// if they were not computed yet. It reports false if s has fewer items.
+//
+// Every item computed takes a step from the fuel of ctx and is accounted on
+// its memory. Items that were already computed are returned right away, even
+// to next itself, while asking for one that is not waits for the turn to
+// compute it, or for ctx to be cancelled.
+func (s *Seq) Nth(ctx *Context, i int) (*Value, bool, error) {
+	for {
+		s.mu.Lock()
+		if i < len(s.items) {
+			value := s.items[i]
+			s.mu.Unlock()
+			return value, true, nil
+		}
+		done := s.done
+		s.mu.Unlock()
+		if done {
+			return nil, false, nil
+		}
+
+		select {
+		case s.turn <- struct{}{}:
+		case <-ctx.done():
+			return nil, false, ctx.Err()
+		}
+		err := s.compute(ctx, i)
+		<-s.turn
+		if err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// compute computes the next item of s, unless item i was computed or s ended
+// while waiting for the turn. It must be called holding the turn.
+func (s *Seq) compute(ctx *Context, i int) error {
+	s.mu.Lock()
+	computed := i < len(s.items) || s.done
+	s.mu.Unlock()
+	if computed {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := ctx.Step(); err != nil {
+		return err
+	}
+	value, ok, err := s.next(ctx)
+	if err != nil {
+		return err
+	}
+	if ok && ctx.mem != nil {
+		// Every item kept takes room of its own, even when the same value
+		// is yielded over and over.
+		if err := ctx.mem.Alloc(value); err != nil {
+			return err
+		}
+		if err := ctx.mem.take(1); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ok {
+		s.done = true
+		s.next = nil
+		return nil
+	}
+	s.items = append(s.items, value)
+	return nil
+}
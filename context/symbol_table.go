@@ -3,6 +3,8 @@ package context
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	//"log"
 )
 
@@ -13,19 +15,29 @@ const (
 	symbolTableTypeDict
 )
 
+// symbolTable binds names to values. It can be read and written from several
+// goroutines at once, entries are replaced as a whole and never changed once
+// they are bound.
 type symbolTable struct {
+	mu sync.RWMutex
+
 	p *symbolTable
 
 	t symbolTableType
 	n map[string]*symbolTable
 	v *Value
+
+	// ns holds the nested dictionaries, apart from n so a namespace and a
+	// value can share a name.
+	ns map[string]*symbolTable
 }
 
 func newSymbolTable(parent *symbolTable) *symbolTable {
 	return &symbolTable{
-		p: parent,
-		t: symbolTableTypeDict,
-		n: make(map[string]*symbolTable),
+		p:  parent,
+		t:  symbolTableTypeDict,
+		n:  make(map[string]*symbolTable),
+		ns: make(map[string]*symbolTable),
 	}
 }
 
@@ -34,10 +46,13 @@ func (st *symbolTable) Set(name string, value *Value) error {
 		return errors.New("not a dictionary")
 	}
 	//log.Printf("ST: %p %v -> %v", st, name, value)
-	st.n[name] = &symbolTable{
+	entry := &symbolTable{
 		t: symbolTableTypeValue,
 		v: value,
 	}
+	st.mu.Lock()
+	st.n[name] = entry
+	st.mu.Unlock()
 	return nil
 }
 
@@ -46,15 +61,62 @@ func (st *symbolTable) Get(name string) (*Value, error) {
 	if st.t != symbolTableTypeDict {
 		return nil, errors.New("not a dictionary")
 	}
-	if value, ok := st.n[name]; ok {
+	st.mu.RLock()
+	value, ok := st.n[name]
+	st.mu.RUnlock()
+	if ok {
 		//log.Printf("ST: %p %v <- %v", st, name, value)
 		if value.t == symbolTableTypeValue {
 			return value.v, nil
 		}
 		return nil, errors.New("key is not a value")
 	}
+	if ns, symbol, ok := SplitName(name); ok {
+		st.mu.RLock()
+		dict, ok := st.ns[ns]
+		st.mu.RUnlock()
+		if ok && dict.t == symbolTableTypeDict {
+			return dict.Get(symbol)
+		}
+	}
 	//if st.p != nil {
 	//	return st.p.Get(name)
 	//}
 	return nil, fmt.Errorf("no such key: %q", name)
 }
+
+// SplitName splits a qualified name like my.lib/parse into the namespace and
+// the symbol. Names that are not qualified, like parse or /, are reported as
+// such.
+func SplitName(name string) (ns string, symbol string, ok bool) {
+	i := strings.Index(name, "/")
+	if i < 1 || i == len(name)-1 {
+		return "", name, false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// SetDict binds name to the dictionary dict.
+func (st *symbolTable) SetDict(name string, dict *symbolTable) error {
+	if st.t != symbolTableTypeDict || dict.t != symbolTableTypeDict {
+		return errors.New("not a dictionary")
+	}
+	st.mu.Lock()
+	st.ns[name] = dict
+	st.mu.Unlock()
+	return nil
+}
+
+// Values returns the values bound on st.
+func (st *symbolTable) Values() map[string]*Value {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	values := map[string]*Value{}
+	for name, entry := range st.n {
+		if entry.t == symbolTableTypeValue {
+			values[name] = entry.v
+		}
+	}
+	return values
+}
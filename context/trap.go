@@ -0,0 +1,32 @@
+package context
+
+import (
+	"sync"
+)
+
+// Trap keeps the first error raised by the contexts it is bound to.
+type Trap struct {
+	mu  sync.Mutex
+	err error
+}
+
+// NewTrap creates an empty trap.
+func NewTrap() *Trap {
+	return &Trap{}
+}
+
+// Set records err unless an earlier error was already recorded.
+func (t *Trap) Set(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// Err returns the recorded error, if any.
+func (t *Trap) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
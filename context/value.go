@@ -3,7 +3,6 @@ package context
 import (
 	"fmt"
 	"log"
-	"sort"
 	"strings"
 
 	"github.com/xiam/sexpr/ast"
@@ -34,7 +33,22 @@ func (f Function) Name() string {
 	return f.name
 }
 
-type Map map[Value]*Value
+// Lambda describes a function defined in fn code.
+type Lambda struct {
+	Name   string
+	Params []*Value
+	Body   *Value
+	// Macro is set for functions that take their arguments unevaluated and
+	// return the code to evaluate in place of the call.
+	Macro bool
+	// Generator is set for functions that yield values from their body, a
+	// call returns a lazy sequence of the values yielded.
+	Generator bool
+}
+
+type expression struct {
+	values []*Value
+}
 
 type ValueType uint8
 
@@ -49,6 +63,10 @@ const (
 	ValueTypeMap
 	ValueTypeList
 	ValueTypeFunction
+	ValueTypeSeq
+	ValueTypeChan
+	ValueTypeFuture
+	ValueTypeRef
 )
 
 func (vt ValueType) String() string {
@@ -69,6 +87,14 @@ func (vt ValueType) String() string {
 		return ":list"
 	case ValueTypeFunction:
 		return ":func"
+	case ValueTypeSeq:
+		return ":seq"
+	case ValueTypeChan:
+		return ":chan"
+	case ValueTypeFuture:
+		return ":future"
+	case ValueTypeRef:
+		return ":ref"
 	}
 
 	panic("reached")
@@ -85,6 +111,9 @@ type Value struct {
 	valueType ValueType
 	v         interface{}
 
+	lambda *Lambda
+	expr   *expression
+
 	name string
 }
 
@@ -138,7 +167,7 @@ func NewValue(node *ast.Node) (*Value, error) {
 	return nil, nil
 }
 
-func (v Value) Type() ValueType {
+func (v *Value) Type() ValueType {
 	return v.valueType
 }
 
@@ -171,7 +200,18 @@ func (v *Value) String() string {
 	case ValueTypeList:
 		return encodeList(v.List())
 	case ValueTypeFunction:
+		if v.expr != nil {
+			return encodeExpression(v.expr.values)
+		}
 		return fmt.Sprintf("<function: %v>", v.v)
+	case ValueTypeSeq:
+		return "<seq>"
+	case ValueTypeChan:
+		return "<chan>"
+	case ValueTypeFuture:
+		return "<future>"
+	case ValueTypeRef:
+		return "<ref>"
 	}
 	panic(fmt.Sprintf("reached: %v", v.Type()))
 	return fmt.Sprintf("%v", v.v)
@@ -184,8 +224,40 @@ func (v *Value) Function() *Function {
 	return NewFunction(fn)
 }
 
-func (v *Value) Map() Map {
-	return v.v.(map[Value]*Value)
+// Lambda returns the definition of a function written in fn code, or nil for
+// builtin functions and other values.
+func (v *Value) Lambda() *Lambda {
+	return v.lambda
+}
+
+// Expression returns the forms of an unevaluated expression, the first one
+// being the function to call and the rest its arguments, or nil for other
+// values.
+func (v *Value) Expression() []*Value {
+	if v.expr == nil {
+		return nil
+	}
+	return v.expr.values
+}
+
+func (v *Value) Map() *Map {
+	return v.v.(*Map)
+}
+
+func (v *Value) Seq() *Seq {
+	return v.v.(*Seq)
+}
+
+func (v *Value) Chan() *Chan {
+	return v.v.(*Chan)
+}
+
+func (v *Value) Future() *Future {
+	return v.v.(*Future)
+}
+
+func (v *Value) Ref() *Ref {
+	return v.v.(*Ref)
 }
 
 func (v *Value) IsFloat() bool {
@@ -222,14 +294,9 @@ func (v *Value) Float() float64 {
 	return 0
 }
 
+// Eq reports whether a and b are Equal.
 func Eq(a *Value, b *Value) bool {
-	if a.Type() != b.Type() {
-		return false
-	}
-	if a.String() != b.String() {
-		return false
-	}
-	return true
+	return Equal(a, b)
 }
 
 func NewArrayValue(v []*Value) *Value {
@@ -291,52 +358,81 @@ func NewIntValue(v int64) *Value {
 	}
 }
 
-func NewMapValue(v map[Value]*Value) *Value {
+func NewMapValue(v *Map) *Value {
 	return &Value{
 		v:         v,
 		valueType: ValueTypeMap,
 	}
 }
 
-func NewFunctionValue(fn func(*Context) error) *Value {
+func NewSeqValue(v *Seq) *Value {
 	return &Value{
-		v:         fn,
-		valueType: ValueTypeFunction,
+		v:         v,
+		valueType: ValueTypeSeq,
 	}
 }
 
-type sortableValue []Value
+func NewChanValue(v *Chan) *Value {
+	return &Value{
+		v:         v,
+		valueType: ValueTypeChan,
+	}
+}
 
-func (sv sortableValue) Len() int {
-	return len(sv)
+func NewFutureValue(v *Future) *Value {
+	return &Value{
+		v:         v,
+		valueType: ValueTypeFuture,
+	}
 }
 
-func (sv sortableValue) Less(i, j int) bool {
-	a, b := sv[i].String(), sv[j].String()
-	return a < b
+func NewRefValue(v *Ref) *Value {
+	return &Value{
+		v:         v,
+		valueType: ValueTypeRef,
+	}
 }
 
-func (sv sortableValue) Swap(i, j int) {
-	sv[i], sv[j] = sv[j], sv[i]
+func NewFunctionValue(fn func(*Context) error) *Value {
+	return &Value{
+		v:         fn,
+		valueType: ValueTypeFunction,
+	}
 }
 
-func encodeMap(value map[Value]*Value) string {
-	items := []string{}
-	keys := sortableValue{}
+// NewLambdaValue creates a function value that runs fn and is described by
+// lambda.
+func NewLambdaValue(lambda *Lambda, fn func(*Context) error) *Value {
+	value := NewFunctionValue(fn)
+	value.lambda = lambda
+	return value
+}
 
-	for k := range value {
-		keys = append(keys, k)
-	}
-	sort.Sort(keys)
+// NewExpressionValue creates a function value that runs fn and stands for
+// the unevaluated expression made of the given forms.
+func NewExpressionValue(values []*Value, fn func(*Context) error) *Value {
+	value := NewFunctionValue(fn)
+	value.expr = &expression{values: values}
+	return value
+}
 
-	for _, k := range keys {
-		v := value[k]
+func encodeMap(value *Map) string {
+	items := []string{}
+	for _, k := range value.Keys() {
+		v, _ := value.Get(k)
 		items = append(items, fmt.Sprintf("%s %s", k.String(), v.String()))
 	}
-
 	return fmt.Sprintf("{%s}", strings.Join(items, " "))
 }
 
+func encodeExpression(values []*Value) string {
+	items := []string{}
+	for i := range values {
+		items = append(items, values[i].String())
+	}
+	return fmt.Sprintf("(%s)", strings.Join(items, " "))
+}
+
 func encodeList(values []*Value) string {
 	items := []string{}
 	for i := range values {
@@ -0,0 +1,104 @@
+package fnlang
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xiam/fnlang/context"
+	"github.com/xiam/sexpr/ast"
+)
+
+// Frame is a call to a fn function, as seen from a runtime error.
+type Frame struct {
+	// Name is the name of the function, or "fn" for anonymous functions.
+	Name string
+	// Line, Column and Source describe the expression the function was
+	// called from.
+	Line   int
+	Column int
+	Source string
+}
+
+// Error is a runtime error raised by fn code.
+type Error struct {
+	Message string
+
+	// Line, Column and Source describe the expression that failed.
+	Line   int
+	Column int
+	Source string
+
+	// Stack holds the fn functions that were running when the error was
+	// raised, starting with the innermost one.
+	Stack []Frame
+
+	err error
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (line %d, column %d)", e.Message, e.Line, e.Column)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Traceback formats the error along with its stack of calls.
+func (e *Error) Traceback() string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "runtime error: %s\n", e.Message)
+	if e.Line > 0 {
+		fmt.Fprintf(buf, "  at line %d, column %d: %s\n", e.Line, e.Column, e.Source)
+	}
+	for _, frame := range e.Stack {
+		if frame.Line > 0 {
+			fmt.Fprintf(buf, "  in %s, called at line %d, column %d: %s\n", frame.Name, frame.Line, frame.Column, frame.Source)
+			continue
+		}
+		fmt.Fprintf(buf, "  in %s\n", frame.Name)
+	}
+	return buf.String()
+}
+
+func nodePosition(n *ast.Node) (line int, column int, source string) {
+	if n == nil {
+		return 0, 0, ""
+	}
+	source = string(ast.Encode(n))
+	if tok := n.Token(); tok != nil {
+		pos := tok.Pos()
+		line, column = pos.Line, pos.Column
+	}
+	return line, column, source
+}
+
+// newError wraps err into an *Error that points at n. Errors that already
+// carry a position and fatal errors are returned as they are.
+func newError(ctx *context.Context, n *ast.Node, err error) error {
+	if IsFatal(err) {
+		return err
+	}
+
+	var rtErr *Error
+	if errors.As(err, &rtErr) {
+		return rtErr
+	}
+
+	rtErr = &Error{
+		Message: err.Error(),
+		err:     err,
+	}
+	rtErr.Line, rtErr.Column, rtErr.Source = nodePosition(n)
+
+	for _, frame := range ctx.Stack() {
+		f := Frame{Name: frame.Name}
+		f.Line, f.Column, f.Source = nodePosition(frame.Node)
+		rtErr.Stack = append(rtErr.Stack, f)
+	}
+
+	return rtErr
+}
@@ -28,6 +28,46 @@ func TestUserError(t *testing.T) {
 	testCases := []struct {
 		In string
 	}{
+		{
+			In: `(< 1 "a")`,
+		},
+		{
+			In: `(yield 1)`,
+		},
+		{
+			In: `
+				(set ch (chan 1))
+				(close ch)
+				(send ch 1)
+			`,
+		},
+		{
+			In: `(await (go (nope)))`,
+		},
+		{
+			In: `(|> [1 2] (fn [x] (/ x 0)))`,
+		},
+		{
+			In: `(|> [1 2] 3)`,
+		},
+		{
+			In: `(range -9223372036854775807 9223372036854775807)`,
+		},
+		{
+			In: `(range 0 9223372036854775807 2)`,
+		},
+		{
+			In: `(str/repeat "ab" 9223372036854775807)`,
+		},
+		{
+			In: `(chan 9223372036854775807)`,
+		},
+		{
+			In: `
+				(defn bad [] (let [] (yield 1) (/ 1 0)))
+				(take 2 (bad))
+			`,
+		},
 		{
 			In: `
 							[
@@ -78,7 +118,145 @@ func TestUserError(t *testing.T) {
 		assert.NoError(t, err)
 
 		_, result, err := fnlang.Eval(root)
-		assert.NoError(t, err)
+		assert.Error(t, err)
+		assert.IsType(t, &fnlang.Error{}, err)
 		assert.NotNil(t, result)
 	}
 }
+
+func TestErrorPosition(t *testing.T) {
+	root, err := parser.Parse([]byte(`
+(defn inner [x]
+  (+ x (missing)))
+(defn outer [x]
+  (* 2 (inner x)))
+(set anon (fn [x] (+ 1 (outer x))))
+(anon 4)
+`))
+	assert.NoError(t, err)
+
+	_, result, err := fnlang.Eval(root)
+	assert.NotNil(t, result)
+
+	rtErr, ok := err.(*fnlang.Error)
+	assert.True(t, ok)
+
+	assert.Equal(t, `no such key: "missing"`, rtErr.Message)
+	assert.Equal(t, 3, rtErr.Line)
+	assert.Contains(t, rtErr.Source, "missing")
+
+	names, lines := []string{}, []int{}
+	for _, frame := range rtErr.Stack {
+		names = append(names, frame.Name)
+		lines = append(lines, frame.Line)
+	}
+	assert.Equal(t, []string{"inner", "outer", "fn"}, names)
+	assert.Equal(t, []int{5, 6, 7}, lines)
+
+	traceback := rtErr.Traceback()
+	assert.Contains(t, traceback, `runtime error: no such key: "missing"`)
+	assert.Contains(t, traceback, "in inner, called at line 5")
+	assert.Contains(t, traceback, "in fn, called at line 7")
+}
+
+func TestErrorPositionTailCall(t *testing.T) {
+	root, err := parser.Parse([]byte(`
+(defn inner [x]
+  (+ x (missing)))
+(defn outer [x]
+  (* 2 (inner x)))
+(set anon (fn [x] (outer x)))
+(anon 4)
+`))
+	assert.NoError(t, err)
+
+	_, result, err := fnlang.Eval(root)
+	assert.NotNil(t, result)
+
+	rtErr, ok := err.(*fnlang.Error)
+	assert.True(t, ok)
+
+	assert.Equal(t, `no such key: "missing"`, rtErr.Message)
+	assert.Equal(t, 3, rtErr.Line)
+	assert.Contains(t, rtErr.Source, "missing")
+
+	names, lines := []string{}, []int{}
+	for _, frame := range rtErr.Stack {
+		names = append(names, frame.Name)
+		lines = append(lines, frame.Line)
+	}
+	// outer is called in tail position, so it replaces the frame of the
+	// anonymous function that called it.
+	assert.Equal(t, []string{"inner", "outer"}, names)
+	assert.Equal(t, []int{5, 6}, lines)
+
+	traceback := rtErr.Traceback()
+	assert.Contains(t, traceback, `runtime error: no such key: "missing"`)
+	assert.Contains(t, traceback, "in inner, called at line 5")
+	assert.Contains(t, traceback, "in outer, called at line 6")
+	assert.NotContains(t, traceback, "in fn")
+}
+
+func TestTryCatch(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+		Err bool
+	}{
+		{
+			In:  `(try (+ 1 2) (catch e :caught))`,
+			Out: `[3]`,
+		},
+		{
+			In:  `(try (:error "boom") (catch e (e :message)))`,
+			Out: `["boom"]`,
+		},
+		{
+			In:  `(try (:error "boom") (catch e (e :line)) (finally (set x 1)))`,
+			Out: `[1]`,
+		},
+		{
+			In: `
+        (defn f [x] (+ x (missing)))
+        (try
+          (f 1)
+          (catch e [(e :message) (e :line)])
+        )
+        (+ 1 2)
+      `,
+			Out: `[:true ["no such key: \"missing\"" 2] 3]`,
+		},
+		{
+			In:  `(try (echo 1) [2 (:error "nested")] (echo 3) (catch e (e :message)))`,
+			Out: `["nested"]`,
+		},
+		{
+			In:  `(try (echo :body) (finally (:error "cleanup failed")))`,
+			Out: `[{:error "cleanup failed"}]`,
+			Err: true,
+		},
+		{
+			In:  `(try (:error "uncaught") (finally 1))`,
+			Out: `[{:error "uncaught"}]`,
+			Err: true,
+		},
+		{
+			In:  `(try (:error "first") (catch e (:error "second")))`,
+			Out: `[{:error "second"}]`,
+			Err: true,
+		},
+	}
+
+	for i := range testCases {
+		root, err := parser.Parse([]byte(testCases[i].In))
+		assert.NoError(t, err)
+
+		_, result, err := fnlang.Eval(root)
+		if testCases[i].Err {
+			assert.IsType(t, &fnlang.Error{}, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, testCases[i].Out, result[0].String())
+	}
+}
@@ -1,38 +1,25 @@
 package fnlang
 
 import (
+	gocontext "context"
+	"errors"
 	"fmt"
-	"log"
 
 	"github.com/xiam/fnlang/context"
 	"github.com/xiam/sexpr/ast"
 )
 
-var defaultContext = context.New(nil).Name("root").Executable()
-
-func Defn(name string, fn func(ctx *context.Context) error) {
-	wrapper := func(ctx *context.Context) error {
-		if err := fn(ctx); err != nil {
-			ctx.Exit(err)
-			return err
-		}
-		ctx.Exit(nil)
-		return nil
-	}
-	if err := defaultContext.Set(name, context.NewFunctionValue(wrapper)); err != nil {
-		log.Fatal("Defn: %w", err)
+func derefFunc(ctx *context.Context, fn *context.Function) (*context.Value, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-}
 
-func derefFunc(ctx *context.Context, fn *context.Function) (*context.Value, error) {
 	execCtx := context.New(ctx).Name("deref-exec")
 
+	fnErr := make(chan error, 1)
 	go func() {
 		defer execCtx.Exit(nil)
-
-		if err := fn.Exec(execCtx); err != nil {
-			log.Fatalf("ERR1: %v", err)
-		}
+		fnErr <- fn.Exec(execCtx)
 	}()
 
 	values, err := execCtx.Results()
@@ -40,6 +27,10 @@ func derefFunc(ctx *context.Context, fn *context.Function) (*context.Value, erro
 		return nil, err
 	}
 
+	if err := <-fnErr; err != nil {
+		return nil, err
+	}
+
 	if len(values.List()) == 1 {
 		return values.List()[0], nil
 	}
@@ -48,6 +39,14 @@ func derefFunc(ctx *context.Context, fn *context.Function) (*context.Value, erro
 }
 
 func execFunc(ctx *context.Context, fn *context.Function, args []*context.Value) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ctx.Step(); err != nil {
+		return err
+	}
+
 	go func() {
 		defer ctx.Close()
 
@@ -59,9 +58,49 @@ func execFunc(ctx *context.Context, fn *context.Function, args []*context.Value)
 	return fn.Exec(ctx)
 }
 
+// callFunc calls fn with the given arguments. Calls to fn functions in tail
+// position are not made here, instead their arguments are evaluated and a
+// *context.TailCall is returned for the enclosing function call to make it.
+func callFunc(ctx *context.Context, fn *context.Value, args []*context.Value) error {
+	if forms := fn.Expression(); forms != nil && len(args) > 0 {
+		// The arguments of a call to an expression follow its own, they are
+		// all fed to the function it holds at once rather than by two
+		// goroutines pushing values on ctx.
+		values := append(append([]*context.Value{}, forms...), args...)
+		fn = prepareFunc(fn.Node(), values)
+	}
+	if fn.Expression() != nil {
+		// An expression evaluates its own forms on ctx and feeds them to the
+		// function it holds, so nothing else feeds ctx.
+		if err := ctx.Step(); err != nil {
+			return err
+		}
+		return fn.Function().Exec(ctx)
+	}
+
+	if !ctx.IsTail() || fn.Lambda() == nil || fn.Lambda().Macro || fn.Lambda().Generator {
+		return execFunc(ctx, fn.Function(), args)
+	}
+
+	if err := ctx.Step(); err != nil {
+		return err
+	}
+
+	values := make([]*context.Value, 0, len(args))
+	for i := range args {
+		value, err := context.ExecArgument(ctx, args[i])
+		if err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+
+	return &context.TailCall{Fn: fn, Args: values, Node: ctx.Node()}
+}
+
 func execExpr(ctx *context.Context, expr *context.Value, values []*context.Value) error {
 	switch expr.Type() {
-	case context.ValueTypeInt:
+	case context.ValueTypeInt, context.ValueTypeFloat:
 		ctx.Yield(expr)
 		return nil
 	case context.ValueTypeString:
@@ -72,7 +111,7 @@ func execExpr(ctx *context.Context, expr *context.Value, values []*context.Value
 		fn, err := ctx.Get(expr.Atom())
 		if err == nil {
 			if fn.Type() == context.ValueTypeFunction {
-				return execFunc(ctx, fn.Function(), values)
+				return callFunc(ctx, fn, values)
 			}
 			return execExpr(ctx, fn, values)
 		}
@@ -102,7 +141,7 @@ func execExpr(ctx *context.Context, expr *context.Value, values []*context.Value
 			return err
 		}
 		if fn.Type() == context.ValueTypeFunction {
-			return execFunc(ctx, fn.Function(), values)
+			return callFunc(ctx, fn, values)
 		}
 		return execExpr(ctx, fn, values)
 	case context.ValueTypeSymbol:
@@ -114,7 +153,7 @@ func execExpr(ctx *context.Context, expr *context.Value, values []*context.Value
 			return err
 		}
 		if fn.Type() == context.ValueTypeFunction {
-			return execFunc(ctx, fn.Function(), values)
+			return callFunc(ctx, fn, values)
 		}
 		return execExpr(ctx, fn, values)
 	}
@@ -124,8 +163,16 @@ func execExpr(ctx *context.Context, expr *context.Value, values []*context.Value
 	return fmt.Errorf("invalid expression type: %v", expr.Type())
 }
 
-func prepareFunc(values []*context.Value) *context.Value {
-	return context.NewFunctionValue(func(ctx *context.Context) error {
+// NewExpression creates an expression that calls values[0] with the rest of
+// values as its arguments, as if it had been read from n.
+func NewExpression(n *ast.Node, values []*context.Value) *context.Value {
+	return prepareFunc(n, values)
+}
+
+func prepareFunc(n *ast.Node, values []*context.Value) *context.Value {
+	fn := context.NewExpressionValue(values, func(ctx *context.Context) error {
+		ctx.At(n)
+
 		if len(values) < 1 {
 			ctx.Yield(context.Nil)
 			return nil
@@ -142,8 +189,13 @@ func prepareFunc(values []*context.Value) *context.Value {
 			}
 		*/
 
-		return execExpr(ctx, expr, values[1:])
+		if err := execExpr(ctx, expr, values[1:]); err != nil {
+			return newError(ctx, n, err)
+		}
+		return nil
 	})
+	fn.SetNode(n)
+	return fn
 }
 
 func evalContextList(ctx *context.Context, nodes []*ast.Node) error {
@@ -155,31 +207,52 @@ func evalContextList(ctx *context.Context, nodes []*ast.Node) error {
 	return nil
 }
 
-func newErrorMap(err error) *context.Value {
+func newErrorMap(err *Error) *context.Value {
 	k := context.NewAtomValue(":error")
-	v := context.NewStringValue(err.Error())
-	return context.NewMapValue(map[context.Value]*context.Value{*k: v})
+	v := context.NewStringValue(err.Message)
+	m := context.NewMap()
+	m.Set(k, v)
+	return context.NewMapValue(m)
 }
 
+// IsFatal reports whether err must abort the whole evaluation instead of
+// being turned into an error value. Fatal errors cannot be caught. Tail calls
+// are reported as fatal too, as they must reach the function call they
+// replace untouched.
+func IsFatal(err error) bool {
+	if _, ok := err.(*context.TailCall); ok {
+		return true
+	}
+	return errors.Is(err, context.ErrCanceled) ||
+		errors.Is(err, context.ErrOutOfFuel) ||
+		errors.Is(err, context.ErrLimitExceeded)
+}
+
+// RuntimeError turns err into an error value that takes the place of the
+// result of ctx, and records it on the trap of ctx as an *Error pointing at
+// n. Fatal errors are returned as they are, so they can abort the evaluation.
 func RuntimeError(ctx *context.Context, n *ast.Node, err error) error {
-	if n == nil {
-		ctx.Yield(newErrorMap(err))
-		ctx.Exit(err)
-		return nil
+	if IsFatal(err) {
+		return err
 	}
-	tok := n.Token()
-	pos := tok.Pos()
-	log.Printf("runtime error: %v (near %v, line: %v, col: %v)",
-		err,
-		fmt.Sprintf("[%s]", string(ast.Encode(n.Parent()))),
-		pos.Line,
-		pos.Column)
-	ctx.Yield(newErrorMap(err))
-	ctx.Exit(err)
+	rtErr := newError(ctx, n, err).(*Error)
+	if trap := ctx.Trap(); trap != nil {
+		trap.Set(rtErr)
+	}
+	ctx.Yield(newErrorMap(rtErr))
+	ctx.Exit(rtErr)
 	return nil
 }
 
 func evalContext(ctx *context.Context, n *ast.Node) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ctx.Step(); err != nil {
+		return err
+	}
+
 	if ctx.Closed() {
 		return nil
 	}
@@ -189,6 +262,9 @@ func evalContext(ctx *context.Context, n *ast.Node) error {
 		if err != nil {
 			return err
 		}
+		if value, err = ctx.Alloc(value); err != nil {
+			return err
+		}
 		return ctx.Yield(value)
 	}
 
@@ -207,6 +283,9 @@ func evalContext(ctx *context.Context, n *ast.Node) error {
 		if err != nil {
 			return err
 		}
+		if value, err = ctx.Alloc(value); err != nil {
+			return err
+		}
 		ctx.Yield(value)
 
 		if err := <-fnErr; err != nil {
@@ -230,22 +309,25 @@ func evalContext(ctx *context.Context, n *ast.Node) error {
 			return nil
 		}()
 
-		result := map[context.Value]*context.Value{}
+		result := context.NewMap()
 		var key *context.Value
 		for {
 			value, err := newCtx.Output()
 			if err != nil {
 				if err == context.ErrClosedChannel {
-					value := context.NewMapValue(result)
+					value, err := ctx.Alloc(context.NewMapValue(result))
+					if err != nil {
+						return err
+					}
 					return ctx.Yield(value)
 				}
 				return err
 			}
 			if key == nil {
 				key = value
-				result[*key] = context.Nil
+				result.Set(key, context.Nil)
 			} else {
-				result[*key] = value
+				result.Set(key, value)
 				key = nil
 			}
 		}
@@ -271,7 +353,7 @@ func evalContext(ctx *context.Context, n *ast.Node) error {
 			return RuntimeError(ctx, n, err)
 		}
 
-		fn := prepareFunc(values.List())
+		fn := prepareFunc(n, values.List())
 
 		if ctx.IsExecutable() {
 			execCtx := context.New(ctx).Name("expr-exec")
@@ -304,44 +386,25 @@ func evalContext(ctx *context.Context, n *ast.Node) error {
 	panic("unreachable")
 }
 
+// Eval evaluates node on the default interpreter.
 func Eval(node *ast.Node) (*context.Context, []*context.Value, error) {
-	newCtx := context.New(defaultContext).Name("eval")
-
-	fnErr := make(chan error, 1)
-	go func() {
-		defer newCtx.Exit(nil)
-		fnErr <- evalContext(newCtx, node)
-	}()
-
-	values, err := newCtx.Collect()
-	if err != nil {
-		return nil, nil, err
-	}
-
-	if err := <-fnErr; err != nil {
-		return nil, nil, err
-	}
-
-	if len(values) == 0 {
-		return newCtx, nil, nil
-	}
-
-	//if len(values) == 1 {
-	//	return newCtx, values[0], nil
-	//}
+	return defaultInterpreter.Eval(node)
+}
 
-	return newCtx, values, nil
+// EvalContext evaluates node on the default interpreter and stops as soon as
+// goctx is done.
+func EvalContext(goctx gocontext.Context, node *ast.Node) (*context.Context, []*context.Value, error) {
+	return defaultInterpreter.EvalContext(goctx, node)
 }
 
 func mapElement(value *context.Value, path []*context.Value) (*context.Value, error) {
 	for i := range path {
-		k := *path[i]
 		if value.Type() == context.ValueTypeMap {
-			_, ok := value.Map()[k]
+			v, ok := value.Map().Get(path[i])
 			if !ok {
 				return context.Nil, nil
 			}
-			value = value.Map()[k]
+			value = v
 		} else {
 			return context.Nil, nil
 		}
@@ -352,13 +415,13 @@ func mapElement(value *context.Value, path []*context.Value) (*context.Value, er
 
 func mapListItem(value *context.Value, path []*context.Value) (*context.Value, error) {
 	for i := range path {
-		k := *path[i]
+		k := path[i]
 		if k.Type() != context.ValueTypeInt {
 			return context.Nil, nil
 		}
 		if value.Type() == context.ValueTypeList {
 			list := value.List()
-			if k.Int() >= int64(len(value.List())) {
+			if k.Int() < 0 || k.Int() >= int64(len(list)) {
 				return context.Nil, nil
 			}
 			value = list[k.Int()]
@@ -1,11 +1,20 @@
 package fnlang_test
 
 import (
+	gocontext "context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xiam/fnlang"
-	_ "github.com/xiam/fnlang/stdlib"
+	"github.com/xiam/fnlang/context"
+	"github.com/xiam/fnlang/stdlib"
 	"github.com/xiam/sexpr/ast"
 	"github.com/xiam/sexpr/parser"
 )
@@ -123,6 +132,10 @@ func TestParserEvaluate(t *testing.T) {
 			In:  `((([["foo" "bar"]])))`,
 			Out: `[[["foo" "bar"]]]`,
 		},
+		{
+			In:  `([1 2 3] 1) ([1 2 3] -1) ([1 2 3] 3) ([[1 2] 3] 0 1)`,
+			Out: `[2 :nil :nil 2]`,
+		},
 		{
 			In:  `(print "hello world!" " beautiful world!")`,
 			Out: `[:nil]`,
@@ -842,6 +855,407 @@ func TestParserEvaluate(t *testing.T) {
         `,
 			Out: `[14 -10 10.01 -9.61 24 -546.48 2 2 0 0.18181818181818182 0.18181818181818182]`,
 		},
+		{
+			In: `
+        (let [a 1 b (+ a 2)] (* a b))
+        (let [] 5)
+        (let [x 7])
+      `,
+			Out: `[3 5 :nil]`,
+		},
+		{
+			In: `
+        (set x 1)
+        (let [x 10 y (+ x 1)] (echo x y))
+        (x)
+        (let [x 2] (set x 3) x)
+        (x)
+      `,
+			Out: `[:true [10 11] 1 3 1]`,
+		},
+		{
+			In: `
+        (defn f [n] (let [m (* n 2)] (+ m 1)))
+        (f 1)
+        (f 5)
+        (get m)
+      `,
+			Out: `[:true 3 11 :nil]`,
+		},
+		{
+			In: `
+        (loop [i 0 s 0] (when (= i 10) s (recur (+ i 1) (+ s i))))
+        (loop [i 3] (when (= i 0) :done (let [j (- i 1)] (recur j))))
+        (loop [] 7)
+      `,
+			Out: `[45 :done 7]`,
+		},
+		{
+			In: `
+        (defn even? [n] (when (= n 0) :true (odd? (- n 1))))
+        (defn odd? [n] (when (= n 0) :false (even? (- n 1))))
+        (even? 10)
+        (odd? 7)
+        (defn down [n] (when (= n 0) :done (recur (- n 1))))
+        (down 5)
+      `,
+			Out: `[:true :true :true :true :true :done]`,
+		},
+		{
+			In: `
+        (defmacro unless [c body] (quasiquote (when (unquote c) :nil (unquote body))))
+        (unless :false (+ 1 2))
+        (unless :true (:error "not evaluated"))
+        (macroexpand (quote (unless x (echo y))))
+      `,
+			Out: `[:true 3 :nil (when x :nil (echo y))]`,
+		},
+		{
+			In: `
+        (quote (+ 1 2))
+        (quote x)
+        (set xs [1 2 3])
+        (quasiquote (+ 10 (unquote-splicing xs)))
+        ((quasiquote (+ 10 (unquote-splicing xs))))
+        (quasiquote [a (unquote (+ 1 1)) (unquote-splicing xs)])
+      `,
+			Out: `[(+ 1 2) x :true (+ 10 1 2 3) 16 [a 2 1 2 3]]`,
+		},
+		{
+			In: `
+        (defmacro with-default [name value body]
+          (quasiquote (let [(unquote name) (unquote value)] (unquote body))))
+        (with-default x 5 (* x 2))
+        (defn down [n] (with-default m (- n 1) (when (= n 0) :done (down m))))
+        (down 2000)
+      `,
+			Out: `[:true 10 :true :done]`,
+		},
+		{
+			In: `
+        (str "a" 1 :b [1 "x"])
+        (str)
+        (str/length "ñandú")
+        (str/substring "ñandú" 1 3)
+        (str/substring "ñandú" 2)
+        (str/split "a,b,c" ",")
+        (str/join ", " ["a" 1 :b])
+      `,
+			Out: `["a1:b[1 \"x\"]" "" 5 "an" "ndú" ["a" "b" "c"] "a, 1, :b"]`,
+		},
+		{
+			In: `
+        (str/trim "  hi  ")
+        (str/trim-left "  hi  ")
+        (str/trim-right "  hi  ")
+        (str/upper "ñandú")
+        (str/lower "ÑANDÚ")
+        (str/contains? "hello" "ell")
+        (str/starts-with? "hello" "lo")
+        (str/index "ñandú" "d")
+        (str/index "abc" "z")
+        (str/replace "a.b.c" "." "/")
+        (str/repeat "ab" 3)
+      `,
+			Out: `["hi" "hi  " "  hi" "ÑANDÚ" "ñandú" :true :false 3 :nil "a/b/c" "ababab"]`,
+		},
+		{
+			In: `
+        (< 1 2 3)
+        (< 1 3 2)
+        (< 1 1.5 2)
+        (>= 2.0 2 1)
+        (<= 1 1)
+        (> "b" "a")
+        (not= 1 2)
+        (not= 1 1)
+      `,
+			Out: `[:true :false :true :true :true :true :true :false]`,
+		},
+		{
+			In: `
+        (not :false)
+        (not 0)
+        (and :true 1 2)
+        (and :true :false (:error "not evaluated"))
+        (or :false :nil 3)
+        (or :false (set y 1) (set z 1))
+        (get z)
+        (and)
+        (or)
+      `,
+			Out: `[:true :false :true :false :true :true :nil :true :false]`,
+		},
+		{
+			In: `
+        (mod -7 3)
+        (rem -7 3)
+        (quot -7 2)
+        (mod 7.5 2)
+        (inc 1)
+        (dec 1.5)
+        (abs -3)
+        (abs -2.5)
+        (min 3 1.5 2)
+        (max 3 1 2)
+      `,
+			Out: `[2 -1 -3 1.5 2 0.5 3 2.5 1.5 3]`,
+		},
+		{
+			In: `
+        (math/pow 2 10)
+        (math/pow 4 0.5)
+        (math/sqrt 16)
+        (math/floor 2.7)
+        (math/ceil 2.1)
+        (math/round 2.5)
+        (math/floor 3)
+        (math/sin 0)
+        (math/cos 0)
+        (* 2 math/pi)
+        (math/pow 1 9223372036854775807)
+        (math/pow -2 63)
+        (math/pow 2 64)
+      `,
+			Out: `[1024 2 4 2 3 3 3 0 1 6.283185307179586 1 -9223372036854775808 1.8446744073709552e+19]`,
+		},
+		{
+			In: `
+        (math/floor (math/pow 2.0 1000))
+        (math/floor (math/pow 2.0 63))
+        (math/ceil (* -1.0 (math/pow 2 63)))
+        (inc 9223372036854775807)
+        (dec -9223372036854775807)
+        (dec (dec -9223372036854775807))
+        (abs (dec -9223372036854775807))
+      `,
+			Out: `[1.0715086071862673e+301 9.223372036854776e+18 -9223372036854775808 9.223372036854776e+18 -9223372036854775808 -9.223372036854776e+18 9.223372036854776e+18]`,
+		},
+		{
+			In: `
+        (try (/ 1 0) (catch e (e :message)))
+        (try (/ 1.5 0) (catch e (e :message)))
+        (try (mod 1 0) (catch e (e :message)))
+        (try (quot 1 0) (catch e (e :message)))
+        (try (math/sqrt -1) (catch e (e :message)))
+      `,
+			Out: `["division by zero" "division by zero" "division by zero" "division by zero" "math/sqrt is undefined for -1"]`,
+		},
+		{
+			In: `
+        (set xs [1 2 3])
+        (map inc xs)
+        (map (fn [x] (* x x)) xs)
+        (map + xs [10 20])
+        (filter (fn [x] (> x 1)) xs)
+        (remove (fn [x] (> x 1)) xs)
+        (reduce + xs)
+        (reduce (fn [acc x] (cons x acc)) [] xs)
+        (reduce + [])
+        (some (fn [x] (when (> x 1) x)) xs)
+        (every? (fn [x] (> x 0)) xs)
+        (map (fn [f] (f 1)) [inc dec])
+        (xs)
+      `,
+			Out: `[:true [2 3 4] [1 4 9] [11 22] [2 3] [1] 6 [3 2 1] :nil 2 :true [2 0] [1 2 3]]`,
+		},
+		{
+			In: `
+        (count [1 2])
+        (count "ñá")
+        (count {:a 1})
+        (first [1 2])
+        (rest [1 2])
+        (first [])
+        (cons 0 [1 2])
+        (concat [1] [2 3] [])
+        (reverse [1 2 3])
+        (range 5)
+        (range 10 0 -3)
+        (take 2 [1 2 3])
+        (drop 2 [1 2 3])
+        (take 9 [1])
+        (nth [1 2 3] 1)
+        (nth [1 2] 5 :none)
+        (range 9223372036854775805 9223372036854775807)
+        (range 9223372036854775807 -9223372036854775807 -9223372036854775807)
+        (range 0 9223372036854775807 4611686018427387904)
+      `,
+			Out: `[2 2 1 1 [2] :nil [0 1 2] [1 2 3] [3 2 1] [0 1 2 3 4] [10 7 4 1] [1 2] [3] [1] 2 :none [9223372036854775805 9223372036854775806] [9223372036854775807 0] [0 4611686018427387904]]`,
+		},
+		{
+			In: `
+        (set m {:a 1 :b 2})
+        (assoc m :c 3)
+        (dissoc m :a)
+        (keys m)
+        (vals m)
+        (merge m {:b 20 :d 4} :nil)
+        (contains? m :a)
+        (contains? m :z)
+        (select-keys m [:a :z])
+        (m)
+      `,
+			Out: `[:true {:a 1 :b 2 :c 3} {:b 2} [:a :b] [1 2] {:a 1 :b 20 :d 4} :true :false {:a 1} {:a 1 :b 2}]`,
+		},
+		{
+			In: `
+        (set n {:x {:y [1 2 {:z 3}]}})
+        (get-in n [:x :y 2 :z])
+        (get-in n [:x :q] :default)
+        (assoc-in n [:x :y 0] 100)
+        (assoc-in {} [:a :b] 1)
+        (update-in n [:x :y 1] + 10)
+        (update-in {:a 1} [:a] inc)
+        (n)
+      `,
+			Out: `[:true 3 :default {:x {:y [100 2 {:z 3}]}} {:a {:b 1}} {:x {:y [1 12 {:z 3}]}} {:a 2} {:x {:y [1 2 {:z 3}]}}]`,
+		},
+		{
+			In: `
+        (set k {[1 2] :pair {:a 1} :map "s" :string})
+        (k [1 2])
+        (k {:a 1})
+        (k "s")
+        (k [2 1])
+        (assoc {} [1 2] :a [1 2] :b)
+        (contains? (assoc {} {:x [1]} 1) {:x [1]})
+      `,
+			Out: `[:true :pair :map :string :nil {[1 2] :b} :true]`,
+		},
+		{
+			In: `
+        (= 1 1.0)
+        (= [1 [2 {:a 3}]] [1.0 [2 {:a 3.0}]])
+        (= {:a 1 :b 2} {:b 2 :a 1})
+        (= [1 2] [1 2 3])
+        (= "1" 1)
+        (assert [1 2] [1.0 2])
+        (sort [3 "b" :a 1.5 [2] [1 5] {:a 1} "a" -1])
+        (sort-by count [[1 2 3] [] [1] [1 2]])
+        (sort-by (fn [m] (m :age)) [{:age 40 :n 1} {:age 20 :n 2} {:age 40 :n 3}])
+        {2 :b 10 :c 1 :a}
+        [(= 9007199254740993 9007199254740992.0) (= 9007199254740992 9007199254740992.0)]
+        [(> 9007199254740993 9007199254740992.0) (< 2 2.5) (>= 3 3.0)]
+      `,
+			Out: `[:true :true :true :false :false :true [-1 1.5 3 :a "a" "b" {:a 1} [1 5] [2]] [[] [1] [1 2] [1 2 3]] [{:age 20 :n 2} {:age 40 :n 1} {:age 40 :n 3}] {1 :a 2 :b 10 :c} [:false :true] [:true :true :true]]`,
+		},
+		{
+			In: `
+        (take 5 (iterate inc 0))
+        (take 3 (repeat :x))
+        (take 5 (repeat 2 :x))
+        (take 5 (cycle [1 2]))
+        (take 3 (map (fn [x] (* x x)) (iterate inc 1)))
+        (take 3 (filter (fn [x] (= 0 (mod x 2))) (iterate inc 1)))
+        (take 3 (map + (iterate inc 0) [10 20]))
+        (first (drop 100 (iterate inc 0)))
+        (nth (cycle [:a :b]) 3)
+      `,
+			Out: `[[0 1 2 3 4] [:x :x :x] [:x :x] [1 2 1 2 1] [1 4 9] [2 4 6] [10 21] 100 :b]`,
+		},
+		{
+			In: `
+        (defn fib [] (loop [a 0 b 1] (yield a) (recur b (+ a b))))
+        (take 10 (fib))
+        (defn three [] (let [] (yield 1) (yield 2) (yield 3)))
+        (set s (three))
+        (take 2 s)
+        (take 5 s)
+        (seq? s)
+        (take 2 (remove (fn [x] (= x 2)) s))
+        (set self (atom :nil))
+        (defn fibs [] (let [] (yield 0) (yield 1) (loop [i 0] (yield (+ (nth (deref self) i) (nth (deref self) (+ i 1)))) (recur (+ i 1)))))
+        (reset! self (fibs))
+        (take 10 (deref self))
+      `,
+			Out: `[:true [0 1 1 2 3 5 8 13 21 34] :true :true [1 2] [1 2 3] :true [1 3] :true :true <seq> [0 1 1 2 3 5 8 13 21 34]]`,
+		},
+		{
+			In: `
+        (await (go (+ 1 2)))
+        (set ch (chan))
+        (go (send ch 42))
+        (recv ch)
+        (set b (chan 2))
+        (send b 1)
+        (send b 2)
+        (close b)
+        [(recv b) (recv b) (recv b)]
+        (select [v (recv (chan))] v (recv (timeout 10)) :timeout)
+        (select (recv (timeout 9223372036854775807)) :never (recv (timeout 10)) :soon)
+        (set c (chan 1))
+        (select (send c :x) :sent :default :full)
+        (select (send c :y) :sent :default :full)
+        (select [v (recv c)] [:got v])
+        (set out (chan))
+        (count (map (fn [n] (go (send out (* n n)))) [1 2 3]))
+        (sort [(recv out) (recv out) (recv out)])
+        (try (await (go (/ 1 0))) (catch e (e :message)))
+      `,
+			Out: `[3 :true <future> 42 :true :true :true :true [1 2 :nil] :timeout :soon :true :sent :full [:got :x] :true 3 [1 4 9] "division by zero"]`,
+		},
+		{
+			In: `
+        [(|> (echo 1 2 3) (fn [x] (* x x)))]
+        (|> (range 5) +)
+        [(|> (range 6) (fn [a b] (+ a b)) (fn [x] (* 10 x)))]
+        (|> [1 2 3] echo echo +)
+        (defn evens [] (loop [i 0] (yield i) (recur (+ i 2))))
+        (|> (take 3 (evens)) str)
+        (|> (iterate inc 0) (fn [x] (< x 5)) and)
+      `,
+			Out: `[[[1 4 9]] 10 [[10 50 90]] 6 :true "024" :false]`,
+		},
+		{
+			In: `
+        (defn fib [n] (when (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))
+        (pcalls (fn [] (fib 10)) (fn [] (fib 11)))
+        (pmap fib [10 11 12])
+        (deref (future (fib 10)))
+        (set p (promise))
+        (go (deliver p 42))
+        (await p)
+        (deliver p 1)
+        (deref (promise) 10 :timeout)
+        (try (pmap (fn [x] (/ 1 x)) [1 0 2]) (catch e (e :message)))
+        (try (deref (future (nope))) (catch e (e :message)))
+      `,
+			Out: `[:true [55 89] [55 89 144] 55 :true <future> 42 :false :timeout "division by zero" "no such key: \"nope\""]`,
+		},
+		{
+			In: `
+        (set counter (atom 0))
+        (set log (atom []))
+        (add-watch counter :log (fn [k r old new] (swap! log concat [[k old new]])))
+        (swap! counter + 10)
+        (remove-watch counter :log)
+        (remove-watch counter :log)
+        (count (pmap (fn [x] (swap! counter inc)) (range 100)))
+        (deref counter)
+        (compare-and-set! counter 0 1)
+        (compare-and-set! counter 110 0)
+        (reset! counter 5)
+        (deref log)
+        (try (swap! counter (fn [x] (/ x 0))) (catch e (e :message)))
+        (deref counter)
+      `,
+			Out: `[:true :true <ref> 10 :true :false 100 110 :false :true 5 [[:log 0 10]] "division by zero" 5]`,
+		},
+		{
+			In: `
+        (map (fn [x] (first [x])) [1 2 3])
+        (defn wrap [x] {:k [x]})
+        [(wrap 1) (wrap 2)]
+        (set xs [1 2 3])
+        (set f (quasiquote (+ 10 (unquote-splicing xs))))
+        [(f) (f) (f)]
+        (pmap (fn [x] [x (* x x)]) [1 2 3])
+        (set g (quote (+ 1 2)))
+        [(g) (g 3) (g 3 4) (g)]
+      `,
+			Out: `[[1 2 3] :true [{:k [1]} {:k [2]}] :true :true [16 16 16] [[1 1] [2 4] [3 9]] :true [3 6 10 3]]`,
+		},
 	}
 	for i := range testCases {
 		root, err := parser.Parse([]byte(testCases[i].In))
@@ -856,3 +1270,678 @@ func TestParserEvaluate(t *testing.T) {
 		assert.Equal(t, testCases[i].Out, result[0].String())
 	}
 }
+
+func TestTailCall(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	{
+		_, values, err := interp.EvalString(`
+      (defn count [n acc] (when (= n 0) acc (count (- n 1) (+ acc 1))))
+      (count 10000 0)
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true 10000]`, values[0].String())
+	}
+
+	{
+		_, values, err := interp.EvalString(`
+      (defn even? [n] (when (= n 0) :true (odd? (- n 1))))
+      (defn odd? [n] (when (= n 0) :false (even? (- n 1))))
+      (even? 5001)
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true :true :false]`, values[0].String())
+	}
+
+	{
+		_, values, err := interp.EvalString(`(loop [i 0] (+ 1 (recur i)))`)
+		assert.IsType(t, &fnlang.Error{}, err)
+		assert.Contains(t, err.Error(), "recur must be in tail position")
+		assert.Equal(t, `[{:error "recur must be in tail position"}]`, values[0].String())
+	}
+
+	{
+		_, _, err := interp.EvalString(`(loop [i 0] (recur 1 2))`)
+		assert.IsType(t, &fnlang.Error{}, err)
+		assert.Contains(t, err.Error(), "recur expects 1 arguments, got 2")
+	}
+}
+
+func TestTailCallConstantSpace(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	// util/probe records the most frames and goroutines in use at any of the
+	// calls it is made from, and returns :false so that it can sit in a when
+	// condition without taking the tail position.
+	var frames, goroutines int
+	interp.Defn("util/probe", func(ctx *context.Context) error {
+		if n := len(ctx.Stack()); n > frames {
+			frames = n
+		}
+		if n := runtime.NumGoroutine(); n > goroutines {
+			goroutines = n
+		}
+		return ctx.Yield(context.False)
+	})
+
+	peak := func(src string) (int, int) {
+		frames, goroutines = 0, 0
+		_, values, err := interp.EvalString(src)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true :done]`, values[0].String())
+		return frames, goroutines
+	}
+
+	for _, fn := range []string{
+		`(defn count [n] (when (= n 0) :done (util/probe) :never (count (- n 1))))`,
+		`(defn count [n] (loop [i n] (when (= i 0) :done (util/probe) :never (recur (- i 1)))))`,
+	} {
+		// A call that does not run in constant space would take at least one
+		// frame more for each of the calls.
+		shallowFrames, shallowGoroutines := peak(fn + ` (count 10)`)
+		deepFrames, deepGoroutines := peak(fn + ` (count 100000)`)
+		assert.Equal(t, shallowFrames, deepFrames)
+		assert.InDelta(t, shallowGoroutines, deepGoroutines, 4)
+	}
+}
+
+func TestSession(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+		Err bool
+	}{
+		{
+			In:  `(set x 6)`,
+			Out: `[:true]`,
+		},
+		{
+			In:  `(defn square [n] (* n n))`,
+			Out: `[:true]`,
+		},
+		{
+			In:  `(square x) (x)`,
+			Out: `[36 6]`,
+		},
+		{
+			In:  `(square 2) (+ 1 (undefined)) (square 4)`,
+			Out: `[4]`,
+			Err: true,
+		},
+		{
+			In:  `(square 3)`,
+			Out: `[9]`,
+		},
+	}
+
+	session := fnlang.NewSession()
+	for i := range testCases {
+		root, err := parser.Parse([]byte(testCases[i].In))
+		assert.NoError(t, err)
+
+		values, err := session.Eval(root)
+		if testCases[i].Err {
+			assert.IsType(t, &fnlang.Error{}, err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, testCases[i].Out, context.NewListValue(values).String())
+	}
+}
+
+func TestSessionCancel(t *testing.T) {
+	session := fnlang.NewSession()
+
+	{
+		root, err := parser.Parse([]byte(`(defn loop [] (loop)) (loop)`))
+		assert.NoError(t, err)
+
+		goctx, cancel := gocontext.WithTimeout(gocontext.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		values, err := session.EvalContext(goctx, root)
+		assert.True(t, errors.Is(err, context.ErrCanceled))
+		assert.Equal(t, `[:true]`, context.NewListValue(values).String())
+	}
+
+	{
+		root, err := parser.Parse([]byte(`(+ 1 2)`))
+		assert.NoError(t, err)
+
+		values, err := session.Eval(root)
+		assert.NoError(t, err)
+		assert.Equal(t, `[3]`, context.NewListValue(values).String())
+	}
+}
+
+func TestInterpreterIsolation(t *testing.T) {
+	a, b := fnlang.NewInterpreter(), fnlang.NewInterpreter()
+	stdlib.Install(a)
+	stdlib.Install(b)
+
+	b.Defn("name", func(ctx *context.Context) error {
+		return ctx.Yield(context.NewStringValue("b"))
+	})
+
+	sa, sb := a.NewSession(), b.NewSession()
+
+	{
+		root, err := parser.Parse([]byte(`(set x 1) (defn f [] :a)`))
+		assert.NoError(t, err)
+		_, err = sa.Eval(root)
+		assert.NoError(t, err)
+	}
+
+	{
+		root, err := parser.Parse([]byte(`(set x 2)`))
+		assert.NoError(t, err)
+		_, err = sb.Eval(root)
+		assert.NoError(t, err)
+	}
+
+	{
+		root, err := parser.Parse([]byte(`(x) (get f)`))
+		assert.NoError(t, err)
+
+		values, err := sa.Eval(root)
+		assert.NoError(t, err)
+		assert.Equal(t, `1`, values[0].String())
+
+		values, err = sb.Eval(root)
+		assert.NoError(t, err)
+		assert.Equal(t, `[2 :nil]`, context.NewListValue(values).String())
+	}
+
+	{
+		_, values, err := a.EvalString(`(name)`)
+		assert.Error(t, err)
+		assert.Equal(t, `[{:error "no such key: \"name\""}]`, values[0].String())
+
+		_, values, err = b.EvalString(`(name)`)
+		assert.NoError(t, err)
+		assert.Equal(t, `["b"]`, values[0].String())
+	}
+}
+
+func TestEvalContextCancel(t *testing.T) {
+	root, err := parser.Parse([]byte(`
+    (defn loop [] (loop))
+    (loop)
+  `))
+	assert.NoError(t, err)
+
+	goroutines := runtime.NumGoroutine()
+
+	goctx, cancel := gocontext.WithTimeout(gocontext.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, _, err = fnlang.EvalContext(goctx, root)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.ErrCanceled))
+	assert.True(t, errors.Is(err, gocontext.DeadlineExceeded))
+
+	for start := time.Now(); time.Since(start) < time.Second; {
+		if runtime.NumGoroutine() <= goroutines {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+}
+
+func TestInterpreterFuel(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	{
+		ctx, values, err := interp.EvalString(`(defn square [x] (* x x)) (square 4)`)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true 16]`, values[0].String())
+		assert.True(t, ctx.Fuel().Used() > 0)
+		assert.Equal(t, int64(-1), ctx.Fuel().Remaining())
+	}
+
+	interp.SetFuel(500)
+
+	{
+		ctx, values, err := interp.EvalString(`(defn square [x] (* x x)) (square 4)`)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true 16]`, values[0].String())
+		assert.Equal(t, int64(500), ctx.Fuel().Used()+ctx.Fuel().Remaining())
+	}
+
+	{
+		ctx, values, err := interp.EvalString(`(defn loop [] (loop)) (loop)`)
+		assert.Equal(t, context.ErrOutOfFuel, err)
+		assert.Nil(t, values)
+		assert.Equal(t, int64(500), ctx.Fuel().Used())
+		assert.Equal(t, int64(0), ctx.Fuel().Remaining())
+	}
+
+	{
+		root, err := parser.Parse([]byte(`(defn loop [] (loop)) (loop)`))
+		assert.NoError(t, err)
+
+		_, err = interp.NewSession().Eval(root)
+		assert.Equal(t, context.ErrOutOfFuel, err)
+	}
+
+	{
+		_, _, err := interp.EvalString(`(nth (repeat 1) 50000000)`)
+		assert.Equal(t, context.ErrOutOfFuel, err)
+	}
+}
+
+func TestInterpreterLimits(t *testing.T) {
+	testCases := []struct {
+		Limits context.Limits
+		In     string
+		Out    string
+		Err    bool
+	}{
+		{
+			Limits: context.Limits{MaxListLength: 3},
+			In:     `(set arr []) (push arr 1 2 3) (arr)`,
+			Out:    `[:true :true [1 2 3]]`,
+		},
+		{
+			Limits: context.Limits{MaxListLength: 3},
+			In:     `(set arr []) (push arr 1 2 3 4) (arr)`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxListLength: 3},
+			In:     `[1 [2 3 4 5]]`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxMapEntries: 1},
+			In:     `{:a 1 :b 2}`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxStringLength: 5},
+			In:     `"hello" "ñandú"`,
+			Out:    `["hello" "ñandú"]`,
+		},
+		{
+			Limits: context.Limits{MaxStringLength: 5},
+			In:     `"hello world"`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxStringLength: 5},
+			In:     `(str/repeat "abc" 1000000000)`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxListLength: 3},
+			In:     `(chan 4)`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxValues: 1000, MaxListLength: 1000},
+			In:     `(nth (repeat 1) 3000000)`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxValues: 10},
+			In:     `1 2 3 4 5 6 7 8 9 10 11`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxListLength: 3},
+			In:     `(concat [1 2] [3 4])`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxListLength: 3},
+			In:     `(cons 0 [1 2 3])`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxListLength: 3},
+			In:     `(map inc [1 2 3]) (reverse [1 2 3]) (sort [3 1 2])`,
+			Out:    `[[2 3 4] [3 2 1] [1 2 3]]`,
+		},
+		{
+			Limits: context.Limits{MaxMapEntries: 1},
+			In:     `(merge {:a 1} {:b 2})`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxStringLength: 5},
+			In:     `(str "abc" "def")`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxStringLength: 5},
+			In:     `(str/join "-" ["abc" "def"])`,
+			Err:    true,
+		},
+		{
+			Limits: context.Limits{MaxStringLength: 5},
+			In:     `(str/replace "aaa" "a" "bb")`,
+			Err:    true,
+		},
+	}
+
+	for i := range testCases {
+		interp := fnlang.NewInterpreter()
+		stdlib.Install(interp)
+		interp.SetLimits(testCases[i].Limits)
+
+		_, values, err := interp.EvalString(testCases[i].In)
+		if testCases[i].Err {
+			assert.True(t, errors.Is(err, context.ErrLimitExceeded))
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, testCases[i].Out, values[0].String())
+	}
+
+	{
+		interp := fnlang.NewInterpreter()
+		stdlib.Install(interp)
+		interp.SetLimits(context.Limits{MaxListLength: 3})
+
+		// Values are accounted as they are yielded, builtins do not need to
+		// call Alloc for them to be bound by the limits.
+		interp.Defn("util/list", func(ctx *context.Context) error {
+			return ctx.Yield(context.NewListValue([]*context.Value{
+				context.NewIntValue(1),
+				context.NewIntValue(2),
+				context.NewIntValue(3),
+				context.NewIntValue(4),
+			}))
+		})
+
+		_, _, err := interp.EvalString(`(util/list)`)
+		assert.True(t, errors.Is(err, context.ErrLimitExceeded))
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	interp.Defn("util/answer", func(ctx *context.Context) error {
+		return ctx.Yield(context.NewIntValue(42))
+	})
+
+	{
+		_, values, err := interp.EvalString(`
+      (core/+ 1 2)
+      (core// 8 2)
+      (util/answer)
+      (defn parse [x] x)
+      (parse 1)
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[3 4 42 :true 1]`, values[0].String())
+	}
+
+	{
+		_, _, err := interp.EvalString(`(answer)`)
+		assert.Error(t, err)
+	}
+
+	interp.Refer("util")
+
+	{
+		_, values, err := interp.EvalString(`(answer)`)
+		assert.NoError(t, err)
+		assert.Equal(t, `[42]`, values[0].String())
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib/math.fn": `
+      (tick)
+      (defn square [x] (* x x))
+      (set two 2)
+    `,
+		"lib/twice.fn": `
+      (require "math.fn")
+      (defn quad [x] (math/square (math/square x)))
+    `,
+		"lib/parser.fn": `
+      (ns my.lib)
+      (defn parse [x] [:parsed x])
+    `,
+		"a.fn": `(require "b.fn")`,
+		"b.fn": `(require "a.fn")`,
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(src), 0644))
+	}
+
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+	interp.SetPath(filepath.Join(dir, "lib"), dir)
+
+	ticks := 0
+	interp.Defn("tick", func(ctx *context.Context) error {
+		ticks++
+		return ctx.Yield(context.Nil)
+	})
+
+	{
+		_, values, err := interp.EvalString(`
+      (require "math.fn")
+      (math/square 5)
+      (math/two)
+      (require "twice.fn")
+      (twice/quad 2)
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true 25 2 :true 16]`, values[0].String())
+	}
+
+	{
+		_, values, err := interp.EvalString(`
+      (load "math.fn")
+      (square 3)
+      (two)
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true 9 2]`, values[0].String())
+	}
+
+	assert.Equal(t, 1, ticks)
+
+	{
+		_, values, err := interp.EvalString(`
+      (require "parser.fn")
+      (my.lib/parse 1)
+      (require "parser.fn" :as p)
+      (p/parse 2)
+      (require "math.fn" :as m)
+      (m/square 3)
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true [:parsed 1] :true [:parsed 2] :true 9]`, values[0].String())
+	}
+
+	{
+		_, values, err := interp.EvalString(`
+      (ns app)
+      (load "parser.fn")
+      (parse 3)
+      (get *ns*)
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[:true :true [:parsed 3] app]`, values[0].String())
+	}
+
+	{
+		_, _, err := interp.EvalString(`(require "a.fn")`)
+		assert.IsType(t, &fnlang.Error{}, err)
+		assert.Contains(t, err.Error(), "import cycle")
+	}
+
+	{
+		_, _, err := interp.EvalString(`(require "missing.fn")`)
+		assert.True(t, errors.Is(err, fnlang.ErrModuleNotFound))
+	}
+}
+
+func TestModulesConcurrentCycle(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.fn": `(util/sync) (require "b.fn")`,
+		"b.fn": `(util/sync) (require "a.fn")`,
+	}
+	for name, src := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0644))
+	}
+
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+	interp.SetPath(dir)
+
+	// util/sync holds each module until both are being loaded, so that each
+	// evaluation requires the module the other one is loading.
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	interp.Defn("util/sync", func(ctx *context.Context) error {
+		arrived.Done()
+		arrived.Wait()
+		return ctx.Yield(context.Nil)
+	})
+
+	errs := make(chan error, 2)
+	for _, name := range []string{"a.fn", "b.fn"} {
+		go func(name string) {
+			_, _, err := interp.EvalString(`(require "` + name + `")`)
+			errs <- err
+		}(name)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.Error(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("concurrent loads deadlocked")
+		}
+	}
+}
+
+func TestTimeoutStopped(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	goroutines := runtime.NumGoroutine()
+
+	_, values, err := interp.EvalString(`(timeout 9223372036854775807) (timeout 60000)`)
+	assert.NoError(t, err)
+	assert.Equal(t, `[<chan> <chan>]`, values[0].String())
+
+	for start := time.Now(); time.Since(start) < time.Second; {
+		if runtime.NumGoroutine() <= goroutines {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+}
+
+func TestGeneratorCancel(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	// util/block hands over the Go context of the generator body and waits
+	// for it to be cancelled, util/entered holds the evaluation until the body
+	// got there.
+	entered := make(chan gocontext.Context, 1)
+	interp.Defn("util/block", func(ctx *context.Context) error {
+		entered <- ctx.Context()
+		<-ctx.Context().Done()
+		return ctx.Err()
+	})
+
+	var blockCtx gocontext.Context
+	interp.Defn("util/entered", func(ctx *context.Context) error {
+		select {
+		case blockCtx = <-entered:
+		case <-time.After(time.Second):
+			return errors.New("generator did not reach util/block")
+		}
+		return ctx.Yield(context.True)
+	})
+
+	_, values, err := interp.EvalString(`
+    (defn gen [] (let [] (yield 1) (util/block)))
+    (take 1 (gen))
+    (util/entered)
+  `)
+	assert.NoError(t, err)
+	assert.Equal(t, `[:true [1] :true]`, values[0].String())
+
+	if assert.NotNil(t, blockCtx) {
+		assert.Error(t, blockCtx.Err(), "generator was not cancelled once the evaluation returned")
+	}
+}
+
+func TestParallelism(t *testing.T) {
+	interp := fnlang.NewInterpreter()
+	stdlib.Install(interp)
+
+	interp.SetParallelism(2)
+	assert.Equal(t, 2, interp.Parallelism())
+
+	var running, maxRunning int64
+	interp.Defn("util/work", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		n := atomic.AddInt64(&running, 1)
+		for {
+			max := atomic.LoadInt64(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt64(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt64(&running, -1)
+		return ctx.Yield(args[0])
+	})
+
+	{
+		_, values, err := interp.EvalString(`(pmap util/work (range 10))`)
+		assert.NoError(t, err)
+		assert.Equal(t, `[[0 1 2 3 4 5 6 7 8 9]]`, values[0].String())
+		assert.True(t, atomic.LoadInt64(&maxRunning) <= 2)
+	}
+
+	{
+		// Goroutines waiting for futures do not run them past the limit.
+		atomic.StoreInt64(&maxRunning, 0)
+		_, values, err := interp.EvalString(`
+      (map await (map (fn [x] (go (deref (future (util/work x))))) (range 10)))
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[[0 1 2 3 4 5 6 7 8 9]]`, values[0].String())
+		assert.True(t, atomic.LoadInt64(&maxRunning) <= 2)
+	}
+
+	{
+		// Futures waited for from within the pool do not wait for a free
+		// worker.
+		interp.SetParallelism(1)
+		_, values, err := interp.EvalString(`
+      (pmap (fn [x] (deref (future (pmap inc (range x (+ x 2)))))) [1 2 3])
+    `)
+		assert.NoError(t, err)
+		assert.Equal(t, `[[[2 3] [3 4] [4 5]]]`, values[0].String())
+	}
+}
@@ -0,0 +1,282 @@
+package fnlang
+
+import (
+	gocontext "context"
+	"log"
+	"sync"
+
+	"github.com/xiam/fnlang/context"
+	"github.com/xiam/sexpr/ast"
+	"github.com/xiam/sexpr/parser"
+)
+
+var defaultInterpreter = NewInterpreter()
+
+// Interpreter owns a root context that holds its builtins. Interpreters do
+// not share any bindings, so scripts evaluated on different interpreters
+// cannot see or clobber each other's definitions.
+type Interpreter struct {
+	root   *context.Context
+	fuel   int64
+	limits context.Limits
+
+	namespaces map[string]*context.Context
+
+	modulesMu sync.Mutex
+	path      []string
+	modules   map[string]*module
+
+	poolMu sync.Mutex
+	slots  chan struct{}
+}
+
+// NewInterpreter creates an interpreter with no builtins defined.
+func NewInterpreter() *Interpreter {
+	return &Interpreter{
+		root:       context.New(nil).Name("root").Executable(),
+		namespaces: map[string]*context.Context{},
+	}
+}
+
+// DefaultInterpreter returns the interpreter used by the package-level
+// functions.
+func DefaultInterpreter() *Interpreter {
+	return defaultInterpreter
+}
+
+// Defn defines a builtin function on the default interpreter.
+func Defn(name string, fn func(ctx *context.Context) error) {
+	defaultInterpreter.Defn(name, fn)
+}
+
+// NewSession creates a session on the default interpreter.
+func NewSession() *Session {
+	return defaultInterpreter.NewSession()
+}
+
+// SetFuel limits the number of steps a single evaluation can take, a step
+// being either the evaluation of an expression or a function call. A limit
+// of zero, the default, removes the bound.
+func (in *Interpreter) SetFuel(steps int64) {
+	in.fuel = steps
+}
+
+// SetLimits bounds the memory a single evaluation can use. Evaluations that
+// go over any of the limits fail with context.ErrLimitExceeded.
+func (in *Interpreter) SetLimits(limits context.Limits) {
+	in.limits = limits
+}
+
+// Defn defines a builtin function on the interpreter's root context. Functions
+// with a qualified name like str/upper are defined on the given namespace,
+// which is created if needed.
+func (in *Interpreter) Defn(name string, fn func(ctx *context.Context) error) {
+	wrapper := func(ctx *context.Context) error {
+		if err := fn(ctx); err != nil {
+			ctx.Exit(err)
+			return err
+		}
+		ctx.Exit(nil)
+		return nil
+	}
+	in.Def(name, context.NewFunctionValue(wrapper))
+}
+
+// Def binds value to name on the interpreter's root context, or on the given
+// namespace for qualified names like math/pi.
+func (in *Interpreter) Def(name string, value *context.Value) {
+	scope := in.root
+	if ns, symbol, ok := context.SplitName(name); ok {
+		scope, name = in.namespace(ns), symbol
+	}
+	if err := scope.Set(name, value); err != nil {
+		log.Fatalf("Def: %v", err)
+	}
+}
+
+// Refer makes the values defined so far on the named namespace available
+// without qualifying them.
+func (in *Interpreter) Refer(ns string) {
+	scope, ok := in.namespaces[ns]
+	if !ok {
+		log.Fatalf("Refer: no such namespace: %q", ns)
+	}
+	if err := in.root.Import(scope); err != nil {
+		log.Fatalf("Refer: %v", err)
+	}
+}
+
+func (in *Interpreter) namespace(name string) *context.Context {
+	ns, ok := in.namespaces[name]
+	if !ok {
+		ns = context.New(nil).Name(name)
+		if err := in.root.SetNamespace(name, ns); err != nil {
+			log.Fatalf("Def: %v", err)
+		}
+		in.namespaces[name] = ns
+	}
+	return ns
+}
+
+// Eval evaluates node on a new scope derived from the interpreter's root
+// context.
+func (in *Interpreter) Eval(node *ast.Node) (*context.Context, []*context.Value, error) {
+	return in.EvalContext(gocontext.Background(), node)
+}
+
+// EvalContext is like Eval but stops as soon as goctx is cancelled or its
+// deadline passes, in which case the returned error matches
+// context.ErrCanceled.
+//
+// The returned context is set even if the evaluation fails, its Fuel reports
+// the number of steps used and the ones left. Evaluations that run out of
+// fuel fail with context.ErrOutOfFuel, while the ones that go over the memory
+// limits fail with context.ErrLimitExceeded.
+//
+// Errors raised by the fn code itself do not stop the evaluation, they are
+// turned into {:error "..."} values instead. In that case the values are
+// returned along with an *Error describing the first of them.
+//
+// Whatever the evaluation started and did not finish, like go blocks or the
+// bodies of generators, is cancelled once EvalContext returns.
+func (in *Interpreter) EvalContext(goctx gocontext.Context, node *ast.Node) (*context.Context, []*context.Value, error) {
+	goctx, cancel := gocontext.WithCancel(goctx)
+	defer cancel()
+
+	newCtx := context.New(in.root).Name("eval").
+		WithContext(goctx).
+		WithFuel(context.NewFuel(in.fuel)).
+		WithMemory(context.NewMemory(in.limits)).
+		WithTrap(context.NewTrap())
+
+	fnErr := make(chan error, 1)
+	go func() {
+		defer newCtx.Exit(nil)
+		fnErr <- evalContext(newCtx, node)
+	}()
+
+	values, err := newCtx.Collect()
+	if err != nil {
+		return newCtx, nil, err
+	}
+
+	if err := <-fnErr; err != nil {
+		return newCtx, nil, err
+	}
+
+	if err := newCtx.Err(); err != nil {
+		return newCtx, nil, err
+	}
+
+	if newCtx.Fuel().Exhausted() {
+		return newCtx, nil, context.ErrOutOfFuel
+	}
+
+	if err := newCtx.Memory().Err(); err != nil {
+		return newCtx, nil, err
+	}
+
+	if len(values) == 0 {
+		values = nil
+	}
+
+	return newCtx, values, newCtx.Trap().Err()
+}
+
+// EvalString parses src and evaluates it.
+func (in *Interpreter) EvalString(src string) (*context.Context, []*context.Value, error) {
+	root, err := parser.Parse([]byte(src))
+	if err != nil {
+		return nil, nil, err
+	}
+	return in.Eval(root)
+}
+
+// NewSession creates a session on top of the interpreter's root context.
+func (in *Interpreter) NewSession() *Session {
+	return &Session{
+		in:  in,
+		ctx: context.New(in.root).Name("session"),
+	}
+}
+
+// Session evaluates forms against a scope that outlives a single call, so
+// bindings created with set or defn remain visible to later evaluations.
+type Session struct {
+	in  *Interpreter
+	ctx *context.Context
+}
+
+// Eval evaluates every top-level form in node and returns one result per
+// form. It stops at the first form that fails and returns the results of the
+// preceding ones. All the forms share the fuel budget and memory limits of the
+// interpreter.
+func (s *Session) Eval(node *ast.Node) ([]*context.Value, error) {
+	return s.EvalContext(gocontext.Background(), node)
+}
+
+// EvalContext is like Eval but stops as soon as goctx is cancelled or its
+// deadline passes, in which case the returned error matches
+// context.ErrCanceled. The session remains usable afterwards.
+func (s *Session) EvalContext(goctx gocontext.Context, node *ast.Node) ([]*context.Value, error) {
+	return s.eval(goctx, node, context.NewFuel(s.in.fuel), context.NewMemory(s.in.limits))
+}
+
+func (s *Session) eval(goctx gocontext.Context, node *ast.Node, fuel *context.Fuel, mem *context.Memory) ([]*context.Value, error) {
+	values := []*context.Value{}
+	for _, n := range node.List() {
+		value, err := s.evalForm(goctx, n, fuel, mem)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (s *Session) evalForm(goctx gocontext.Context, n *ast.Node, fuel *context.Fuel, mem *context.Memory) (*context.Value, error) {
+	newCtx := context.NewClosure(s.ctx).Name("session-eval").
+		WithContext(goctx).
+		WithFuel(fuel).
+		WithMemory(mem).
+		WithTrap(context.NewTrap())
+
+	fnErr := make(chan error, 1)
+	go func() {
+		defer newCtx.Exit(nil)
+		fnErr <- evalContext(newCtx, n)
+	}()
+
+	values, err := newCtx.Collect()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := <-fnErr; err != nil {
+		return nil, err
+	}
+
+	if err := newCtx.Err(); err != nil {
+		return nil, err
+	}
+
+	if fuel.Exhausted() {
+		return nil, context.ErrOutOfFuel
+	}
+
+	if err := mem.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := newCtx.Trap().Err(); err != nil {
+		return nil, err
+	}
+
+	switch len(values) {
+	case 0:
+		return context.Nil, nil
+	case 1:
+		return values[0], nil
+	}
+	return context.NewListValue(values), nil
+}
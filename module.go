@@ -0,0 +1,193 @@
+package fnlang
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xiam/fnlang/context"
+	"github.com/xiam/sexpr/parser"
+)
+
+// ErrModuleNotFound is returned when a module cannot be found on the search
+// path.
+var ErrModuleNotFound = errors.New("module not found")
+
+type loadingKey struct{}
+
+type module struct {
+	path  string
+	scope *context.Context
+	done  chan struct{}
+	err   error
+
+	// waiting is the module the evaluation of this one waits on, either
+	// because it is loading it or because someone else is. It is guarded by
+	// the modulesMu of the interpreter.
+	waiting *module
+}
+
+// cycle returns the modules that would wait on each other if from waited on
+// m, or nil if waiting is safe.
+func (m *module) cycle(from *module) []string {
+	paths := []string{from.path}
+	for w := m; w != nil; w = w.waiting {
+		paths = append(paths, w.path)
+		if w == from {
+			return paths
+		}
+	}
+	return nil
+}
+
+// SetPath sets the directories modules are looked up in, in order. Modules
+// loaded by other modules are looked up in the directory of the latter first,
+// and paths that are absolute are loaded as they are. The default search path
+// holds only the current directory.
+func (in *Interpreter) SetPath(dirs ...string) {
+	in.modulesMu.Lock()
+	defer in.modulesMu.Unlock()
+	in.path = dirs
+}
+
+func (in *Interpreter) resolve(name string, chain []string) (string, error) {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name), nil
+	}
+
+	in.modulesMu.Lock()
+	dirs := in.path
+	in.modulesMu.Unlock()
+
+	if dirs == nil {
+		dirs = []string{"."}
+	}
+	if len(chain) > 0 {
+		dirs = append([]string{filepath.Dir(chain[len(chain)-1])}, dirs...)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return filepath.Abs(path)
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrModuleNotFound, name)
+}
+
+// LoadModule returns the scope holding the top-level bindings of the named
+// module. A module is evaluated only once per interpreter, the first time it
+// is loaded, later loads return the same scope. Modules that load each other
+// in a cycle fail to load, even when the loads are made by different
+// evaluations.
+//
+// The module is evaluated on the fuel and memory budget of ctx, and stops at
+// its first error.
+func (in *Interpreter) LoadModule(ctx *context.Context, name string) (*context.Context, error) {
+	goctx := ctx.Context()
+	chain, _ := goctx.Value(loadingKey{}).([]string)
+
+	path, err := in.resolve(name, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range chain {
+		if chain[i] == path {
+			cycle := append(append([]string{}, chain[i:]...), path)
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	in.modulesMu.Lock()
+	if in.modules == nil {
+		in.modules = map[string]*module{}
+	}
+	// Loads made by other evaluations are not on chain, so the modules
+	// being loaded record what they wait on and a wait that would close a
+	// cycle fails instead of blocking forever.
+	var from *module
+	if len(chain) > 0 {
+		from = in.modules[chain[len(chain)-1]]
+	}
+	m, ok := in.modules[path]
+	if !ok {
+		m = &module{path: path, done: make(chan struct{})}
+		in.modules[path] = m
+	} else if from != nil {
+		if cycle := m.cycle(from); cycle != nil {
+			in.modulesMu.Unlock()
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if from != nil {
+		from.waiting = m
+		defer func() {
+			in.modulesMu.Lock()
+			from.waiting = nil
+			in.modulesMu.Unlock()
+		}()
+	}
+	in.modulesMu.Unlock()
+
+	if ok {
+		select {
+		case <-m.done:
+		case <-goctx.Done():
+			return nil, ctx.Err()
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+		return m.scope, nil
+	}
+
+	goctx = gocontext.WithValue(goctx, loadingKey{}, append(chain[:len(chain):len(chain)], path))
+	m.scope, m.err = in.evalModule(goctx, ctx, path)
+	if m.err != nil {
+		// Failed modules are not cached, so they can be loaded again once
+		// fixed.
+		in.modulesMu.Lock()
+		delete(in.modules, path)
+		in.modulesMu.Unlock()
+	}
+	close(m.done)
+
+	return m.scope, m.err
+}
+
+func (in *Interpreter) evalModule(goctx gocontext.Context, ctx *context.Context, path string) (*context.Context, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parser.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	s := &Session{
+		in:  in,
+		ctx: context.New(in.root).Name(path).WithContext(goctx),
+	}
+
+	fuel, mem := ctx.Fuel(), ctx.Memory()
+	if fuel == nil {
+		fuel = context.NewFuel(in.fuel)
+	}
+	if mem == nil {
+		mem = context.NewMemory(in.limits)
+	}
+
+	if _, err := s.eval(goctx, root, fuel, mem); err != nil {
+		if IsFatal(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return s.ctx, nil
+}
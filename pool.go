@@ -0,0 +1,101 @@
+package fnlang
+
+import (
+	gocontext "context"
+	"runtime"
+
+	"github.com/xiam/fnlang/context"
+)
+
+// SetParallelism sets how many functions submitted with Submit can run at the
+// same time. A value of zero or less, the default, uses the number of CPUs.
+func (in *Interpreter) SetParallelism(n int) {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	in.poolMu.Lock()
+	defer in.poolMu.Unlock()
+	in.slots = make(chan struct{}, n)
+}
+
+// Parallelism returns how many functions submitted with Submit can run at the
+// same time.
+func (in *Interpreter) Parallelism() int {
+	return cap(in.workerSlots())
+}
+
+func (in *Interpreter) workerSlots() chan struct{} {
+	in.poolMu.Lock()
+	defer in.poolMu.Unlock()
+	if in.slots == nil {
+		in.slots = make(chan struct{}, runtime.GOMAXPROCS(0))
+	}
+	return in.slots
+}
+
+// poolTaskKey marks the Go context of the functions that run on the worker
+// pool, and of everything they evaluate.
+type poolTaskKey struct{}
+
+func inPool(ctx *context.Context) bool {
+	inPool, _ := ctx.Context().Value(poolTaskKey{}).(bool)
+	return inPool
+}
+
+// Submit runs fn on the worker pool of the interpreter and returns a future
+// for its result. fn is given a context derived from ctx and waits for a free
+// worker, unless the future is waited for with Await before that. If ctx is
+// cancelled before fn starts the future fails with the cancellation error.
+func (in *Interpreter) Submit(ctx *context.Context, fn func(ctx *context.Context) (*context.Value, error)) *context.Future {
+	taskCtx := context.New(ctx).Name("task")
+	taskCtx.WithContext(gocontext.WithValue(ctx.Context(), poolTaskKey{}, true))
+
+	future := context.NewTask(func() (*context.Value, error) {
+		return fn(taskCtx)
+	})
+	slots := in.workerSlots()
+
+	go func() {
+		select {
+		case slots <- struct{}{}:
+		case <-future.Done():
+			return
+		case <-ctx.Context().Done():
+			future.Resolve(nil, ctx.Err())
+			return
+		}
+		defer func() {
+			<-slots
+		}()
+		future.Run()
+	}()
+
+	return future
+}
+
+// Await waits for future and returns its result. If the future is a task that
+// no worker took yet it is run right away on the goroutine that waits, as long
+// as that does not make more tasks run at once than the pool allows: either a
+// worker is free, and Await takes it while the task runs, or Await is called
+// from a task that is already running on the pool, which lends its worker to
+// the task it waits for. The latter is what keeps tasks that wait for other
+// tasks from taking every worker and waiting forever.
+//
+// Everything a task evaluates counts as part of it, including the goroutines
+// it starts with go or |>, so those may lend its worker as well.
+func (in *Interpreter) Await(ctx *context.Context, future *context.Future) (*context.Value, error) {
+	if future.Pending() {
+		if inPool(ctx) {
+			future.Run()
+		} else {
+			slots := in.workerSlots()
+			select {
+			case slots <- struct{}{}:
+				future.Run()
+				<-slots
+			default:
+			}
+		}
+	}
+	return future.Wait(ctx)
+}
@@ -0,0 +1,132 @@
+package stdlib
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+// truthy reports whether value counts as true for the boolean functions,
+// which is the case for everything but :false and :nil.
+func truthy(value *context.Value) bool {
+	return !context.Eq(value, context.False) && !context.Eq(value, context.Nil)
+}
+
+// compare returns -1, 0 or 1 depending on whether a is lesser than, equal to
+// or greater than b. Integers and floats can be compared with each other, the
+// same way they mix in arithmetic, and strings are compared with strings.
+func compare(a *context.Value, b *context.Value) (int, error) {
+	if isNumeric(a) && isNumeric(b) {
+		// NaN is neither lesser nor greater than any number.
+		if isNaN(a) || isNaN(b) {
+			return 0, nil
+		}
+		return context.Compare(a, b), nil
+	}
+	if a.Type() == context.ValueTypeString && b.Type() == context.ValueTypeString {
+		return strings.Compare(a.Symbol(), b.Symbol()), nil
+	}
+	return 0, fmt.Errorf("cannot compare %v with %v", a.Type(), b.Type())
+}
+
+func isNaN(value *context.Value) bool {
+	return value.IsFloat() && math.IsNaN(value.Float())
+}
+
+func isNumeric(value *context.Value) bool {
+	return value.Type() == context.ValueTypeInt || value.Type() == context.ValueTypeFloat
+}
+
+// comparison defines a function that checks whether each argument and the
+// next one are in the order accepted by ok.
+func comparison(in *fnlang.Interpreter, name string, ok func(int) bool) {
+	in.Defn(name, func(ctx *context.Context) error {
+		var prev *context.Value
+		result := true
+		for ctx.Next() {
+			value, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			if prev != nil && result {
+				c, err := compare(prev, value)
+				if err != nil {
+					return err
+				}
+				result = ok(c)
+			}
+			prev = value
+		}
+		return yieldBool(ctx, result)
+	})
+}
+
+func installCompare(in *fnlang.Interpreter) {
+
+	comparison(in, "core/<", func(c int) bool { return c < 0 })
+	comparison(in, "core/>", func(c int) bool { return c > 0 })
+	comparison(in, "core/<=", func(c int) bool { return c <= 0 })
+	comparison(in, "core/>=", func(c int) bool { return c >= 0 })
+
+	in.Defn("core/not=", func(ctx *context.Context) error {
+		var first *context.Value
+		equal := true
+		for ctx.Next() {
+			value, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			if first == nil {
+				first = value
+				continue
+			}
+			if !context.Equal(first, value) {
+				equal = false
+			}
+		}
+		return yieldBool(ctx, !equal)
+	})
+
+	in.Defn("core/not", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("not expects 1 argument, got %d", len(args))
+		}
+		return yieldBool(ctx, !truthy(args[0]))
+	})
+
+	in.Defn("core/and", func(ctx *context.Context) error {
+		for ctx.Next() {
+			value, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			if !truthy(value) {
+				// The rest of the arguments are never pulled, so they are
+				// not evaluated.
+				return yieldBool(ctx, false)
+			}
+		}
+		return yieldBool(ctx, true)
+	})
+
+	in.Defn("core/or", func(ctx *context.Context) error {
+		for ctx.Next() {
+			value, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			if truthy(value) {
+				return yieldBool(ctx, true)
+			}
+		}
+		return yieldBool(ctx, false)
+	})
+
+}
@@ -0,0 +1,471 @@
+package stdlib
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+var defaultClause = context.NewAtomValue(":default")
+
+func chanArgument(name string, value *context.Value) (*context.Chan, error) {
+	if value.Type() != context.ValueTypeChan {
+		return nil, fmt.Errorf("%s expects a channel, got %v", name, value.Type())
+	}
+	return value.Chan(), nil
+}
+
+func futureArgument(name string, value *context.Value) (*context.Future, error) {
+	if value.Type() != context.ValueTypeFuture {
+		return nil, fmt.Errorf("%s expects a future, got %v", name, value.Type())
+	}
+	return value.Future(), nil
+}
+
+// milliseconds converts ms into a duration, holding the longest duration
+// there is for counts that would overflow it.
+func milliseconds(ms int) time.Duration {
+	if ms > int(math.MaxInt64/time.Millisecond) {
+		return math.MaxInt64
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// checkChanSize fails for channel buffers that are too large to be made, or
+// larger than the memory limits of ctx allow a list to be.
+func checkChanSize(ctx *context.Context, size int) error {
+	if size > maxLength {
+		return fmt.Errorf("chan of %d values is too large", size)
+	}
+	if mem := ctx.Memory(); mem != nil {
+		if max := mem.Limits().MaxListLength; max > 0 && size > max {
+			return fmt.Errorf("%w: chan of %d values is larger than %d", context.ErrLimitExceeded, size, max)
+		}
+	}
+	return nil
+}
+
+// rawArguments returns the arguments of ctx unevaluated.
+func rawArguments(ctx *context.Context) ([]*context.Value, error) {
+	args := []*context.Value{}
+	for ctx.NonExecutable().Next() {
+		arg, err := ctx.Argument()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	ctx.Executable()
+	return args, nil
+}
+
+// runDetached evaluates body on a new scope of ctx and returns the last
+// result. The body runs with a trap of its own, so that its errors are kept
+// for whoever waits for the result instead of stopping the evaluation that
+// started it.
+func runDetached(ctx *context.Context, name string, body []*context.Value) (*context.Value, error) {
+	scope := context.New(ctx).Name(name).Executable().WithTrap(context.NewTrap())
+	result := context.Nil
+	for i := range body {
+		var err error
+		result, err = evalValue(scope, body[i])
+		if err == nil {
+			err = scope.Trap().Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// awaitFunc defines a function that waits for a future or a promise and
+// returns its value, or a default value if a timeout in milliseconds is given
+// and the wait takes longer than that. Given an atom it returns its current
+// value.
+func awaitFunc(in *fnlang.Interpreter, name string) {
+	in.Defn(name, func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 && len(args) != 3 {
+			return fmt.Errorf("%s expects 1 or 3 arguments, got %d", name, len(args))
+		}
+		if len(args) == 1 && args[0].Type() == context.ValueTypeRef {
+			return ctx.Yield(args[0].Ref().Deref())
+		}
+		future, err := futureArgument(name, args[0])
+		if err != nil {
+			return err
+		}
+		if len(args) == 3 {
+			ms, err := intArgument(name, args[1])
+			if err != nil {
+				return err
+			}
+			timer := time.NewTimer(milliseconds(ms))
+			defer timer.Stop()
+			select {
+			case <-future.Done():
+			case <-timer.C:
+				return ctx.Yield(args[2])
+			case <-ctx.Context().Done():
+				return ctx.Err()
+			}
+		}
+		value, err := in.Await(ctx, future)
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(value)
+	})
+}
+
+// parallel calls fn for every one of n items on the worker pool and returns
+// the results in order. On the first error, in the order of the items, the
+// calls that are still running are cancelled and the error is returned.
+func parallel(in *fnlang.Interpreter, ctx *context.Context, n int, fn func(ctx *context.Context, i int) (*context.Value, error)) ([]*context.Value, error) {
+	goctx, cancel := gocontext.WithCancel(ctx.Context())
+	defer cancel()
+	taskCtx := context.New(ctx).Name("parallel").WithContext(goctx)
+
+	futures := make([]*context.Future, n)
+	for i := range futures {
+		i := i
+		futures[i] = in.Submit(taskCtx, func(ctx *context.Context) (*context.Value, error) {
+			return fn(ctx, i)
+		})
+	}
+
+	results := make([]*context.Value, n)
+	for i := range futures {
+		value, err := in.Await(ctx, futures[i])
+		if err != nil {
+			return nil, err
+		}
+		results[i] = value
+	}
+	return results, nil
+}
+
+// selectCase evaluates the channel operation of a select clause, which is
+// either (recv ch) or (send ch value).
+func selectCase(ctx *context.Context, op *context.Value) (context.SelectCase, error) {
+	forms := op.Expression()
+	if len(forms) > 0 && forms[0].Type() == context.ValueTypeSymbol {
+		switch name := forms[0].Symbol(); name {
+		case "recv", "core/recv":
+			if len(forms) != 2 {
+				return context.SelectCase{}, fmt.Errorf("recv expects 1 argument, got %d", len(forms)-1)
+			}
+			value, err := evalValue(ctx, forms[1])
+			if err != nil {
+				return context.SelectCase{}, err
+			}
+			ch, err := chanArgument("recv", value)
+			if err != nil {
+				return context.SelectCase{}, err
+			}
+			return context.SelectCase{Chan: ch}, nil
+		case "send", "core/send":
+			if len(forms) != 3 {
+				return context.SelectCase{}, fmt.Errorf("send expects 2 arguments, got %d", len(forms)-1)
+			}
+			value, err := evalValue(ctx, forms[1])
+			if err != nil {
+				return context.SelectCase{}, err
+			}
+			ch, err := chanArgument("send", value)
+			if err != nil {
+				return context.SelectCase{}, err
+			}
+			if value, err = evalValue(ctx, forms[2]); err != nil {
+				return context.SelectCase{}, err
+			}
+			return context.SelectCase{Chan: ch, Send: value}, nil
+		}
+	}
+	return context.SelectCase{}, fmt.Errorf("select expects a recv or send operation, got %v", op)
+}
+
+func installConcurrency(in *fnlang.Interpreter) {
+
+	in.Defn("core/go", func(ctx *context.Context) error {
+		body, err := rawArguments(ctx)
+		if err != nil {
+			return err
+		}
+		if len(body) < 1 {
+			return errors.New("go expects at least one expression")
+		}
+
+		future := context.NewFuture()
+		go func() {
+			future.Resolve(runDetached(ctx, "go", body))
+		}()
+
+		return ctx.Yield(context.NewFutureValue(future))
+	})
+
+	in.Defn("core/future", func(ctx *context.Context) error {
+		body, err := rawArguments(ctx)
+		if err != nil {
+			return err
+		}
+		if len(body) < 1 {
+			return errors.New("future expects at least one expression")
+		}
+
+		future := in.Submit(ctx, func(ctx *context.Context) (*context.Value, error) {
+			return runDetached(ctx, "future", body)
+		})
+
+		return ctx.Yield(context.NewFutureValue(future))
+	})
+
+	awaitFunc(in, "core/await")
+	awaitFunc(in, "core/deref")
+
+	in.Defn("core/promise", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("promise expects no arguments, got %d", len(args))
+		}
+		return ctx.Yield(context.NewFutureValue(context.NewFuture()))
+	})
+
+	in.Defn("core/deliver", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("deliver expects 2 arguments, got %d", len(args))
+		}
+		future, err := futureArgument("deliver", args[0])
+		if err != nil {
+			return err
+		}
+		return yieldBool(ctx, future.Resolve(args[1], nil))
+	})
+
+	in.Defn("core/pmap", func(ctx *context.Context) error {
+		fn, list, err := fnListArguments(ctx, "pmap")
+		if err != nil {
+			return err
+		}
+		items, err := parallel(in, ctx, len(list), func(ctx *context.Context, i int) (*context.Value, error) {
+			return call(ctx, fn, list[i])
+		})
+		if err != nil {
+			return err
+		}
+		return yieldList(ctx, items)
+	})
+
+	in.Defn("core/pcalls", func(ctx *context.Context) error {
+		fns, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		items, err := parallel(in, ctx, len(fns), func(ctx *context.Context, i int) (*context.Value, error) {
+			return call(ctx, fns[i])
+		})
+		if err != nil {
+			return err
+		}
+		return yieldList(ctx, items)
+	})
+
+	in.Defn("core/chan", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		size := 0
+		switch len(args) {
+		case 0:
+		case 1:
+			if size, err = intArgument("chan", args[0]); err != nil {
+				return err
+			}
+			if size < 0 {
+				return fmt.Errorf("chan expects a non-negative size, got %d", size)
+			}
+			if err := checkChanSize(ctx, size); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("chan expects 0 or 1 arguments, got %d", len(args))
+		}
+		value, err := ctx.Alloc(context.NewChanValue(context.NewChan(size)))
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(value)
+	})
+
+	in.Defn("core/send", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("send expects 2 arguments, got %d", len(args))
+		}
+		ch, err := chanArgument("send", args[0])
+		if err != nil {
+			return err
+		}
+		if err := ch.Send(ctx, args[1]); err != nil {
+			return err
+		}
+		return ctx.Yield(context.True)
+	})
+
+	in.Defn("core/recv", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("recv expects 1 argument, got %d", len(args))
+		}
+		ch, err := chanArgument("recv", args[0])
+		if err != nil {
+			return err
+		}
+		value, _, err := ch.Recv(ctx)
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(value)
+	})
+
+	in.Defn("core/close", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("close expects 1 argument, got %d", len(args))
+		}
+		ch, err := chanArgument("close", args[0])
+		if err != nil {
+			return err
+		}
+		if err := ch.Close(); err != nil {
+			return err
+		}
+		return ctx.Yield(context.True)
+	})
+
+	in.Defn("core/timeout", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("timeout expects 1 argument, got %d", len(args))
+		}
+		ms, err := intArgument("timeout", args[0])
+		if err != nil {
+			return err
+		}
+		ch := context.NewChan(0)
+		// The timer goes away along with the evaluation, so long timeouts do
+		// not outlive it.
+		timer := time.NewTimer(milliseconds(ms))
+		done := ctx.Context().Done()
+		go func() {
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				ch.Close()
+			case <-done:
+			}
+		}()
+		return ctx.Yield(context.NewChanValue(ch))
+	})
+
+	in.Defn("core/select", func(ctx *context.Context) error {
+		args, err := rawArguments(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(args)%2 != 0 {
+			return errors.New("select expects pairs of operations and bodies")
+		}
+
+		type clause struct {
+			name string
+			body *context.Value
+		}
+
+		var cases []context.SelectCase
+		var clauses []clause
+		var defaultBody *context.Value
+
+		for i := 0; i < len(args); i += 2 {
+			op, body := args[i], args[i+1]
+			if context.Equal(op, defaultClause) {
+				defaultBody = body
+				continue
+			}
+			name := ""
+			if op.Type() == context.ValueTypeList {
+				// [name (recv ch)] binds the received value to name.
+				items := op.List()
+				if len(items) != 2 || items[0].Type() != context.ValueTypeSymbol {
+					return errors.New("select expects [name (recv ch)] to bind a value")
+				}
+				name, op = items[0].Symbol(), items[1]
+			}
+			c, err := selectCase(ctx, op)
+			if err != nil {
+				return err
+			}
+			if name != "" && c.Send != nil {
+				return errors.New("select can only bind received values")
+			}
+			cases = append(cases, c)
+			clauses = append(clauses, clause{name: name, body: body})
+		}
+
+		i, value, err := context.Select(ctx, cases, defaultBody == nil)
+		if err != nil {
+			return err
+		}
+
+		scope, body := ctx, defaultBody
+		if i >= 0 {
+			body = clauses[i].body
+			if name := clauses[i].name; name != "" {
+				if value == nil {
+					value = context.Nil
+				}
+				scope = context.New(ctx).Name("select").Executable()
+				if err := scope.Set(name, value); err != nil {
+					return err
+				}
+			}
+		}
+
+		result, err := evalTailValue(scope, body, ctx.IsTail())
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(result)
+	})
+
+}
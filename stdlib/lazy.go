@@ -0,0 +1,252 @@
+package stdlib
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+var errYieldOutsideGenerator = errors.New("yield called outside of a generator")
+
+// generatorKey is the key under which the channel a generator sends its
+// values on is stored in the Go context of its body.
+type generatorKey struct{}
+
+// yields reports whether form calls yield, which makes the function it is
+// the body of a generator. Functions defined within form and quoted code are
+// not looked into.
+func yields(form *context.Value) bool {
+	if forms := form.Expression(); len(forms) > 0 {
+		if forms[0].Type() == context.ValueTypeSymbol {
+			switch forms[0].Symbol() {
+			case "yield", "core/yield":
+				return true
+			case "fn", "core/fn", "defn", "core/defn", "defmacro", "core/defmacro",
+				"quote", "core/quote", "quasiquote", "core/quasiquote":
+				return false
+			}
+		}
+		for _, f := range forms {
+			if yields(f) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch form.Type() {
+	case context.ValueTypeList:
+		for _, item := range form.List() {
+			if yields(item) {
+				return true
+			}
+		}
+	case context.ValueTypeMap:
+		m := form.Map()
+		for _, k := range m.Keys() {
+			v, _ := m.Get(k)
+			if yields(k) || yields(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newGenerator returns a lazy sequence of the values yielded by the body of
+// lambda called with args. The body starts running when the first item is
+// asked for and runs until its next yield every time an item is taken, so it
+// is never further ahead than one item. The body runs on behalf of the
+// evaluation that called lambda, taking its fuel and memory, and is cancelled
+// along with it if the sequence is not done by then.
+func newGenerator(ctx *context.Context, lambda *context.Lambda, args []*context.Value) *context.Value {
+	goctx, cancel := gocontext.WithCancel(ctx.Context())
+
+	// The values yielded are sent on ch rather than yielded on the context
+	// of the body, as yield runs on goroutines of its own that may still be
+	// sending once the body is cancelled and done.
+	var ch *context.Chan
+	var genCtx *context.Context
+	var fnErr chan error
+	finished := false
+
+	seq := context.NewSeq(func(pullCtx *context.Context) (*context.Value, bool, error) {
+		if finished {
+			return nil, false, nil
+		}
+		if genCtx == nil {
+			ch = context.NewChan(0)
+			genCtx = context.New(ctx).Name("generator")
+			genCtx.WithContext(gocontext.WithValue(goctx, generatorKey{}, ch))
+
+			fnErr = make(chan error, 1)
+			go func() {
+				defer genCtx.Exit(nil)
+				defer ch.Close()
+				_, _, err := runLambda(genCtx, lambda, args)
+				fnErr <- err
+			}()
+		}
+
+		value, ok, err := ch.Recv(genCtx)
+		if err == nil && ok {
+			return value, true, nil
+		}
+
+		finished = true
+		defer cancel()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := <-fnErr; err != nil {
+			return nil, false, err
+		}
+		return nil, false, nil
+	})
+
+	return context.NewSeqValue(seq)
+}
+
+func seqArgument(value *context.Value) (*context.Seq, bool) {
+	if value.Type() != context.ValueTypeSeq {
+		return nil, false
+	}
+	return value.Seq(), true
+}
+
+// nthItem returns the item at index i of a list or a sequence, and false if
+// there is no such item.
+func nthItem(ctx *context.Context, coll *context.Value, i int) (*context.Value, bool, error) {
+	if seq, ok := seqArgument(coll); ok {
+		return seq.Nth(ctx, i)
+	}
+	list := coll.List()
+	if i >= len(list) {
+		return nil, false, nil
+	}
+	return list[i], true, nil
+}
+
+// collArgument fails unless value is a list or a sequence.
+func collArgument(name string, value *context.Value) error {
+	switch value.Type() {
+	case context.ValueTypeList, context.ValueTypeSeq:
+		return nil
+	}
+	return fmt.Errorf("%s expects a list or a sequence, got %v", name, value.Type())
+}
+
+func yieldSeq(ctx *context.Context, next func(ctx *context.Context) (*context.Value, bool, error)) error {
+	return ctx.Yield(context.NewSeqValue(context.NewSeq(next)))
+}
+
+func installLazy(in *fnlang.Interpreter) {
+
+	in.Defn("core/yield", func(ctx *context.Context) error {
+		ch, ok := ctx.Context().Value(generatorKey{}).(*context.Chan)
+		if !ok {
+			return errYieldOutsideGenerator
+		}
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("yield expects 1 argument, got %d", len(args))
+		}
+		if err := ch.Send(ctx, args[0]); err != nil {
+			return err
+		}
+		return ctx.Yield(args[0])
+	})
+
+	in.Defn("core/iterate", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("iterate expects 2 arguments, got %d", len(args))
+		}
+		fn := args[0]
+		var value *context.Value
+		return yieldSeq(ctx, func(ctx *context.Context) (*context.Value, bool, error) {
+			if value == nil {
+				value = args[1]
+				return value, true, nil
+			}
+			next, err := call(ctx, fn, value)
+			if err != nil {
+				return nil, false, err
+			}
+			value = next
+			return value, true, nil
+		})
+	})
+
+	in.Defn("core/repeat", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		n := -1
+		switch len(args) {
+		case 1:
+		case 2:
+			if n, err = intArgument("repeat", args[0]); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("repeat expects 1 or 2 arguments, got %d", len(args))
+		}
+		value := args[len(args)-1]
+		return yieldSeq(ctx, func(ctx *context.Context) (*context.Value, bool, error) {
+			if n == 0 {
+				return nil, false, nil
+			}
+			if n > 0 {
+				n--
+			}
+			return value, true, nil
+		})
+	})
+
+	in.Defn("core/cycle", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("cycle expects 1 argument, got %d", len(args))
+		}
+		list, err := listArgument("cycle", args[0])
+		if err != nil {
+			return err
+		}
+		i := 0
+		return yieldSeq(ctx, func(ctx *context.Context) (*context.Value, bool, error) {
+			if len(list) == 0 {
+				return nil, false, nil
+			}
+			value := list[i%len(list)]
+			i++
+			return value, true, nil
+		})
+	})
+
+	in.Defn("core/seq?", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("seq? expects 1 argument, got %d", len(args))
+		}
+		_, ok := seqArgument(args[0])
+		return yieldBool(ctx, ok)
+	})
+
+}
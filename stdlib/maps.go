@@ -0,0 +1,299 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+func mapArgument(name string, value *context.Value) (*context.Map, error) {
+	if context.Eq(value, context.Nil) {
+		return context.NewMap(), nil
+	}
+	if value.Type() != context.ValueTypeMap {
+		return nil, fmt.Errorf("%s expects a map, got %v", name, value.Type())
+	}
+	return value.Map(), nil
+}
+
+func yieldMap(ctx *context.Context, m *context.Map) error {
+	value, err := ctx.Alloc(context.NewMapValue(m))
+	if err != nil {
+		return err
+	}
+	return ctx.Yield(value)
+}
+
+// lookup returns the item of coll at key, which is a key for maps and an
+// index for lists.
+func lookup(coll *context.Value, key *context.Value) (*context.Value, bool) {
+	switch coll.Type() {
+	case context.ValueTypeMap:
+		return coll.Map().Get(key)
+	case context.ValueTypeList:
+		if key.Type() != context.ValueTypeInt {
+			return nil, false
+		}
+		list, i := coll.List(), int(key.Int())
+		if i < 0 || i >= len(list) {
+			return nil, false
+		}
+		return list[i], true
+	}
+	return nil, false
+}
+
+// assocIn returns a copy of coll with value stored at path, creating maps for
+// the keys that are missing along the way.
+func assocIn(ctx *context.Context, coll *context.Value, path []*context.Value, value *context.Value) (*context.Value, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	inner, ok := lookup(coll, path[0])
+	if !ok {
+		inner = context.Nil
+	}
+	inner, err := assocIn(ctx, inner, path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+
+	if coll.Type() == context.ValueTypeList {
+		list, i := coll.List(), int(path[0].Int())
+		if path[0].Type() != context.ValueTypeInt || i < 0 || i >= len(list) {
+			return nil, fmt.Errorf("index %v out of bounds for length %d", path[0], len(list))
+		}
+		items := append([]*context.Value{}, list...)
+		items[i] = inner
+		return ctx.Alloc(context.NewListValue(items))
+	}
+
+	m, err := mapArgument("assoc-in", coll)
+	if err != nil {
+		return nil, err
+	}
+	c := m.Copy()
+	c.Set(path[0], inner)
+	return ctx.Alloc(context.NewMapValue(c))
+}
+
+func pathArgument(name string, value *context.Value) ([]*context.Value, error) {
+	if value.Type() != context.ValueTypeList {
+		return nil, fmt.Errorf("%s expects a list of keys, got %v", name, value.Type())
+	}
+	return value.List(), nil
+}
+
+func installMaps(in *fnlang.Interpreter) {
+
+	in.Defn("core/assoc", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) < 1 || len(args)%2 != 1 {
+			return errors.New("assoc expects a map followed by keys and values")
+		}
+		m, err := mapArgument("assoc", args[0])
+		if err != nil {
+			return err
+		}
+		c := m.Copy()
+		for i := 1; i < len(args); i += 2 {
+			c.Set(args[i], args[i+1])
+		}
+		return yieldMap(ctx, c)
+	})
+
+	in.Defn("core/dissoc", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) < 1 {
+			return errors.New("dissoc expects a map followed by keys")
+		}
+		m, err := mapArgument("dissoc", args[0])
+		if err != nil {
+			return err
+		}
+		c := m.Copy()
+		for _, key := range args[1:] {
+			c.Delete(key)
+		}
+		return yieldMap(ctx, c)
+	})
+
+	in.Defn("core/keys", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("keys expects 1 argument, got %d", len(args))
+		}
+		m, err := mapArgument("keys", args[0])
+		if err != nil {
+			return err
+		}
+		return yieldList(ctx, m.Keys())
+	})
+
+	in.Defn("core/vals", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("vals expects 1 argument, got %d", len(args))
+		}
+		m, err := mapArgument("vals", args[0])
+		if err != nil {
+			return err
+		}
+		items := []*context.Value{}
+		for _, k := range m.Keys() {
+			v, _ := m.Get(k)
+			items = append(items, v)
+		}
+		return yieldList(ctx, items)
+	})
+
+	in.Defn("core/merge", func(ctx *context.Context) error {
+		c := context.NewMap()
+		for ctx.Next() {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			m, err := mapArgument("merge", arg)
+			if err != nil {
+				return err
+			}
+			for _, k := range m.Keys() {
+				v, _ := m.Get(k)
+				c.Set(k, v)
+			}
+		}
+		return yieldMap(ctx, c)
+	})
+
+	in.Defn("core/contains?", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("contains? expects 2 arguments, got %d", len(args))
+		}
+		switch args[0].Type() {
+		case context.ValueTypeMap, context.ValueTypeList:
+			_, ok := lookup(args[0], args[1])
+			return yieldBool(ctx, ok)
+		}
+		return fmt.Errorf("contains? expects a map or a list, got %v", args[0].Type())
+	})
+
+	in.Defn("core/select-keys", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("select-keys expects 2 arguments, got %d", len(args))
+		}
+		m, err := mapArgument("select-keys", args[0])
+		if err != nil {
+			return err
+		}
+		keys, err := pathArgument("select-keys", args[1])
+		if err != nil {
+			return err
+		}
+		c := context.NewMap()
+		for _, key := range keys {
+			if v, ok := m.Get(key); ok {
+				c.Set(key, v)
+			}
+		}
+		return yieldMap(ctx, c)
+	})
+
+	in.Defn("core/get-in", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("get-in expects 2 or 3 arguments, got %d", len(args))
+		}
+		path, err := pathArgument("get-in", args[1])
+		if err != nil {
+			return err
+		}
+		value := args[0]
+		for _, key := range path {
+			var ok bool
+			if value, ok = lookup(value, key); !ok {
+				if len(args) == 3 {
+					return ctx.Yield(args[2])
+				}
+				return ctx.Yield(context.Nil)
+			}
+		}
+		return ctx.Yield(value)
+	})
+
+	in.Defn("core/assoc-in", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 3 {
+			return fmt.Errorf("assoc-in expects 3 arguments, got %d", len(args))
+		}
+		path, err := pathArgument("assoc-in", args[1])
+		if err != nil {
+			return err
+		}
+		value, err := assocIn(ctx, args[0], path, args[2])
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(value)
+	})
+
+	in.Defn("core/update-in", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) < 3 {
+			return errors.New("update-in expects a map, a list of keys and a function")
+		}
+		path, err := pathArgument("update-in", args[1])
+		if err != nil {
+			return err
+		}
+		old := args[0]
+		for _, key := range path {
+			var ok bool
+			if old, ok = lookup(old, key); !ok {
+				old = context.Nil
+				break
+			}
+		}
+		value, err := call(ctx, args[2], append([]*context.Value{old}, args[3:]...)...)
+		if err != nil {
+			return err
+		}
+		if value, err = assocIn(ctx, args[0], path, value); err != nil {
+			return err
+		}
+		return ctx.Yield(value)
+	})
+
+}
@@ -0,0 +1,266 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+var errDivisionByZero = errors.New("division by zero")
+
+// numericArguments reads exactly n numeric arguments of ctx.
+func numericArguments(ctx *context.Context, name string, n int) ([]*context.Value, error) {
+	args, err := ctx.Arguments()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, n, len(args))
+	}
+	for i := range args {
+		if !isNumeric(args[i]) {
+			return nil, fmt.Errorf("%s expects a number, got %v", name, args[i].Type())
+		}
+	}
+	return args, nil
+}
+
+// divisionFunc defines a function of a dividend and a divisor, the latter
+// cannot be zero. fn is given integers unless any of the arguments is a float.
+func divisionFunc(in *fnlang.Interpreter, name string, intFn func(a, b int64) int64, floatFn func(a, b float64) float64) {
+	in.Defn(name, func(ctx *context.Context) error {
+		args, err := numericArguments(ctx, name, 2)
+		if err != nil {
+			return err
+		}
+		a, b := args[0], args[1]
+		if b.Float() == 0 {
+			return errDivisionByZero
+		}
+		if a.IsFloat() || b.IsFloat() {
+			return ctx.Yield(context.NewFloatValue(floatFn(a.Float(), b.Float())))
+		}
+		return ctx.Yield(context.NewIntValue(intFn(a.Int(), b.Int())))
+	})
+}
+
+// floatFunc defines a function of a single number that always returns a
+// float. Arguments for which fn is undefined are reported as errors.
+func floatFunc(in *fnlang.Interpreter, name string, fn func(float64) float64) {
+	in.Defn(name, func(ctx *context.Context) error {
+		args, err := numericArguments(ctx, name, 1)
+		if err != nil {
+			return err
+		}
+		result := fn(args[0].Float())
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return fmt.Errorf("%s is undefined for %v", name, args[0])
+		}
+		return ctx.Yield(context.NewFloatValue(result))
+	})
+}
+
+// roundingFunc defines a function that rounds a number into an integer.
+// Results that do not fit in an integer are given as floats.
+func roundingFunc(in *fnlang.Interpreter, name string, fn func(float64) float64) {
+	in.Defn(name, func(ctx *context.Context) error {
+		args, err := numericArguments(ctx, name, 1)
+		if err != nil {
+			return err
+		}
+		if !args[0].IsFloat() {
+			return ctx.Yield(args[0])
+		}
+		result := fn(args[0].Float())
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return fmt.Errorf("%s is undefined for %v", name, args[0])
+		}
+		if result >= math.MinInt64 && result < -math.MinInt64 {
+			return ctx.Yield(context.NewIntValue(int64(result)))
+		}
+		return ctx.Yield(context.NewFloatValue(result))
+	})
+}
+
+// extremumFunc defines a function that returns the argument that is kept by
+// keep when compared with each of the others.
+func extremumFunc(in *fnlang.Interpreter, name string, keep func(c int) bool) {
+	in.Defn(name, func(ctx *context.Context) error {
+		var result *context.Value
+		for ctx.Next() {
+			value, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			if !isNumeric(value) {
+				return fmt.Errorf("%s expects a number, got %v", name, value.Type())
+			}
+			if result == nil {
+				result = value
+				continue
+			}
+			c, err := compare(value, result)
+			if err != nil {
+				return err
+			}
+			if keep(c) {
+				result = value
+			}
+		}
+		if result == nil {
+			return fmt.Errorf("%s expects at least one argument", name)
+		}
+		return ctx.Yield(result)
+	})
+}
+
+// mulInt multiplies x by y and reports whether the result did not overflow.
+func mulInt(x int64, y int64) (int64, bool) {
+	if x == 0 || y == 0 {
+		return 0, true
+	}
+	result := x * y
+	if result/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+		return 0, false
+	}
+	return result, true
+}
+
+// powInt raises base to the non-negative exponent exp by squaring, and
+// reports whether the result fits in an integer.
+func powInt(base int64, exp int64) (int64, bool) {
+	result := int64(1)
+	for {
+		if exp&1 == 1 {
+			var ok bool
+			if result, ok = mulInt(result, base); !ok {
+				return 0, false
+			}
+		}
+		exp >>= 1
+		if exp == 0 {
+			return result, true
+		}
+		var ok bool
+		if base, ok = mulInt(base, base); !ok {
+			return 0, false
+		}
+	}
+}
+
+func installMath(in *fnlang.Interpreter) {
+
+	divisionFunc(in, "core/quot",
+		func(a, b int64) int64 { return a / b },
+		func(a, b float64) float64 { return math.Trunc(a / b) },
+	)
+
+	divisionFunc(in, "core/rem",
+		func(a, b int64) int64 { return a % b },
+		math.Mod,
+	)
+
+	divisionFunc(in, "core/mod",
+		func(a, b int64) int64 {
+			m := a % b
+			if m != 0 && (m < 0) != (b < 0) {
+				m += b
+			}
+			return m
+		},
+		func(a, b float64) float64 {
+			m := math.Mod(a, b)
+			if m != 0 && (m < 0) != (b < 0) {
+				m += b
+			}
+			return m
+		},
+	)
+
+	in.Defn("core/inc", func(ctx *context.Context) error {
+		args, err := numericArguments(ctx, "core/inc", 1)
+		if err != nil {
+			return err
+		}
+		if args[0].IsFloat() || args[0].Int() == math.MaxInt64 {
+			return ctx.Yield(context.NewFloatValue(args[0].Float() + 1))
+		}
+		return ctx.Yield(context.NewIntValue(args[0].Int() + 1))
+	})
+
+	in.Defn("core/dec", func(ctx *context.Context) error {
+		args, err := numericArguments(ctx, "core/dec", 1)
+		if err != nil {
+			return err
+		}
+		if args[0].IsFloat() || args[0].Int() == math.MinInt64 {
+			return ctx.Yield(context.NewFloatValue(args[0].Float() - 1))
+		}
+		return ctx.Yield(context.NewIntValue(args[0].Int() - 1))
+	})
+
+	in.Defn("core/abs", func(ctx *context.Context) error {
+		args, err := numericArguments(ctx, "core/abs", 1)
+		if err != nil {
+			return err
+		}
+		if args[0].IsFloat() || args[0].Int() == math.MinInt64 {
+			return ctx.Yield(context.NewFloatValue(math.Abs(args[0].Float())))
+		}
+		if n := args[0].Int(); n < 0 {
+			return ctx.Yield(context.NewIntValue(-n))
+		}
+		return ctx.Yield(args[0])
+	})
+
+	extremumFunc(in, "core/min", func(c int) bool { return c < 0 })
+	extremumFunc(in, "core/max", func(c int) bool { return c > 0 })
+
+	in.Defn("math/pow", func(ctx *context.Context) error {
+		args, err := numericArguments(ctx, "math/pow", 2)
+		if err != nil {
+			return err
+		}
+		base, exp := args[0], args[1]
+		if !base.IsFloat() && !exp.IsFloat() && exp.Int() >= 0 {
+			// Results that do not fit in an integer are given as floats.
+			if result, ok := powInt(base.Int(), exp.Int()); ok {
+				return ctx.Yield(context.NewIntValue(result))
+			}
+		}
+		result := math.Pow(base.Float(), exp.Float())
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return fmt.Errorf("math/pow is undefined for %v and %v", base, exp)
+		}
+		return ctx.Yield(context.NewFloatValue(result))
+	})
+
+	in.Defn("math/atan2", func(ctx *context.Context) error {
+		args, err := numericArguments(ctx, "math/atan2", 2)
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(context.NewFloatValue(math.Atan2(args[0].Float(), args[1].Float())))
+	})
+
+	floatFunc(in, "math/sqrt", math.Sqrt)
+	floatFunc(in, "math/exp", math.Exp)
+	floatFunc(in, "math/log", math.Log)
+	floatFunc(in, "math/sin", math.Sin)
+	floatFunc(in, "math/cos", math.Cos)
+	floatFunc(in, "math/tan", math.Tan)
+	floatFunc(in, "math/asin", math.Asin)
+	floatFunc(in, "math/acos", math.Acos)
+	floatFunc(in, "math/atan", math.Atan)
+
+	roundingFunc(in, "math/floor", math.Floor)
+	roundingFunc(in, "math/ceil", math.Ceil)
+	roundingFunc(in, "math/round", math.Round)
+
+	in.Def("math/pi", context.NewFloatValue(math.Pi))
+	in.Def("math/e", context.NewFloatValue(math.E))
+
+}
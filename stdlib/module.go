@@ -0,0 +1,122 @@
+package stdlib
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+// moduleName returns the name of the namespace a module is required as: the
+// one declared with ns or, if none, the base name of its file without the
+// extension.
+func moduleName(scope *context.Context, path string) string {
+	if ns, err := scope.Get("*ns*"); err == nil && ns.Type() == context.ValueTypeSymbol {
+		return ns.Symbol()
+	}
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// aliasArgument reads the optional :as alias argument of require.
+func aliasArgument(ctx *context.Context) (string, error) {
+	ctx = ctx.NonExecutable()
+	if !ctx.Next() {
+		return "", nil
+	}
+	opt, err := ctx.Argument()
+	if err != nil {
+		return "", err
+	}
+	if opt.Type() != context.ValueTypeAtom || opt.Atom() != ":as" || !ctx.Next() {
+		return "", errors.New("expecting :as followed by a name")
+	}
+	alias, err := ctx.Argument()
+	if err != nil {
+		return "", err
+	}
+	if alias.Type() != context.ValueTypeSymbol {
+		return "", errors.New("expecting :as followed by a name")
+	}
+	return alias.Symbol(), nil
+}
+
+func moduleArgument(ctx *context.Context) (string, error) {
+	if !ctx.Next() {
+		return "", errors.New("expecting a module path")
+	}
+	arg, err := ctx.Argument()
+	if err != nil {
+		return "", err
+	}
+	if arg.Type() != context.ValueTypeString {
+		return "", errors.New("expecting a module path")
+	}
+	return arg.Symbol(), nil
+}
+
+func installModules(in *fnlang.Interpreter) {
+
+	in.Defn("core/require", func(ctx *context.Context) error {
+		path, err := moduleArgument(ctx)
+		if err != nil {
+			return err
+		}
+		alias, err := aliasArgument(ctx)
+		if err != nil {
+			return err
+		}
+		scope, err := in.LoadModule(ctx, path)
+		if err != nil {
+			return err
+		}
+		if alias == "" {
+			alias = moduleName(scope, path)
+		}
+		if err := ctx.Parent.SetNamespace(alias, scope); err != nil {
+			return err
+		}
+		ctx.Yield(context.True)
+		return nil
+	})
+
+	in.Defn("core/ns", func(ctx *context.Context) error {
+		ctx = ctx.NonExecutable()
+		if !ctx.Next() {
+			return errors.New("ns expects a name")
+		}
+		name, err := ctx.Argument()
+		if err != nil {
+			return err
+		}
+		if name.Type() != context.ValueTypeSymbol {
+			return errors.New("ns expects a name")
+		}
+		if err := ctx.Parent.Set("*ns*", name); err != nil {
+			return err
+		}
+		ctx.Yield(context.True)
+		return nil
+	})
+
+	in.Defn("core/load", func(ctx *context.Context) error {
+		path, err := moduleArgument(ctx)
+		if err != nil {
+			return err
+		}
+		scope, err := in.LoadModule(ctx, path)
+		if err != nil {
+			return err
+		}
+		// The namespace the module declares is its own, the caller stays on
+		// the one it was.
+		if err := ctx.Parent.Import(scope, "*ns*"); err != nil {
+			return err
+		}
+		ctx.Yield(context.True)
+		return nil
+	})
+
+}
@@ -0,0 +1,196 @@
+package stdlib
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+// feedSource evaluates the source of a pipeline on ctx and yields its
+// values as they are produced, lists and sequences one item at a time.
+func feedSource(ctx *context.Context, source *context.Value) error {
+	if source.Type() != context.ValueTypeFunction {
+		value, err := context.ExecArgument(ctx, source)
+		if err != nil {
+			return err
+		}
+		return yieldItems(ctx, value)
+	}
+
+	srcCtx := context.New(ctx).Name("|> source")
+	fnErr := make(chan error, 1)
+	go func() {
+		defer srcCtx.Exit(nil)
+		fnErr <- source.Function().Exec(srcCtx)
+	}()
+
+	for {
+		value, err := srcCtx.Output()
+		if err == context.ErrClosedChannel {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := yieldItems(ctx, value); err != nil {
+			return err
+		}
+	}
+	return <-fnErr
+}
+
+// yieldItems yields the items of value if it is a list or a sequence, and
+// value itself otherwise.
+func yieldItems(ctx *context.Context, value *context.Value) error {
+	switch value.Type() {
+	case context.ValueTypeList:
+		return ctx.Yield(value.List()...)
+	case context.ValueTypeSeq:
+		for i := 0; ; i++ {
+			item, ok, err := value.Seq().Nth(ctx, i)
+			if err != nil || !ok {
+				return err
+			}
+			if err := ctx.Yield(item); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Yield(value)
+}
+
+// pipe pushes the values yielded on src as the arguments of dst, one at a
+// time and only once dst asks for the next one, then closes the input of
+// dst.
+func pipe(src *context.Context, dst *context.Context) {
+	defer dst.Close()
+	for {
+		value, err := src.Output()
+		if err != nil {
+			return
+		}
+		if !dst.Accept() {
+			return
+		}
+		if err := dst.Push(value); err != nil {
+			return
+		}
+	}
+}
+
+// runStage runs fn as a stage of a pipeline, taking its arguments from the
+// values pushed on ctx. Builtins are called once and read as many of them as
+// they want, while fn functions are called again for every group of values
+// that fills their parameters.
+func runStage(ctx *context.Context, fn *context.Value) error {
+	lambda := fn.Lambda()
+	if lambda == nil || lambda.Macro || lambda.Generator {
+		return fn.Function().Exec(ctx)
+	}
+
+	for {
+		args := []*context.Value{}
+		for len(args) < len(lambda.Params) && ctx.Next() {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			args = append(args, arg)
+		}
+		if len(args) == 0 && len(lambda.Params) > 0 {
+			return nil
+		}
+
+		if err := ctx.Step(); err != nil {
+			return err
+		}
+		values, _, err := runLambda(ctx, lambda, args)
+		if err != nil {
+			return err
+		}
+		if err := ctx.Yield(values...); err != nil {
+			return err
+		}
+
+		if len(args) < len(lambda.Params) || len(lambda.Params) == 0 {
+			return nil
+		}
+	}
+}
+
+func installPipe(in *fnlang.Interpreter) {
+
+	in.Defn("core/|>", func(ctx *context.Context) error {
+		args, err := rawArguments(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(args) < 1 {
+			return errors.New("|> expects a source and functions")
+		}
+
+		stages := make([]*context.Value, len(args)-1)
+		for i := range stages {
+			fn, err := evalValue(ctx, args[i+1])
+			if err != nil {
+				return err
+			}
+			if fn.Type() != context.ValueTypeFunction {
+				return fmt.Errorf("%v is not a function", fn)
+			}
+			stages[i] = fn
+		}
+
+		// Every stage runs on its own goroutine. Once the last one is done
+		// the pipeline is cancelled, which stops the stages that are still
+		// trying to yield values nobody is going to read.
+		goctx, cancel := gocontext.WithCancel(ctx.Context())
+		defer cancel()
+		pipeCtx := context.New(ctx).Name("|>").WithContext(goctx)
+
+		errs := make(chan error, len(stages)+1)
+
+		last := context.New(pipeCtx).Name("|> source")
+		go func(src *context.Context) {
+			defer src.Exit(nil)
+			errs <- feedSource(src, args[0])
+		}(last)
+
+		for i := range stages {
+			stageCtx := context.New(pipeCtx).Name("|> stage").NonExecutable()
+			go pipe(last, stageCtx)
+			go func(stageCtx *context.Context, fn *context.Value) {
+				defer stageCtx.Exit(nil)
+				errs <- runStage(stageCtx, fn)
+			}(stageCtx, stages[i])
+			last = stageCtx
+		}
+
+		for {
+			value, err := last.Output()
+			if err != nil {
+				break
+			}
+			if err := ctx.Yield(value); err != nil {
+				return err
+			}
+		}
+		cancel()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var pipeErr error
+		for i := 0; i < len(stages)+1; i++ {
+			if err := <-errs; err != nil && pipeErr == nil && !errors.Is(err, context.ErrCanceled) {
+				pipeErr = err
+			}
+		}
+		return pipeErr
+	})
+
+}
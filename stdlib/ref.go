@@ -0,0 +1,127 @@
+package stdlib
+
+import (
+	"fmt"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+func refArgument(name string, value *context.Value) (*context.Ref, error) {
+	if value.Type() != context.ValueTypeRef {
+		return nil, fmt.Errorf("%s expects an atom, got %v", name, value.Type())
+	}
+	return value.Ref(), nil
+}
+
+func installRefs(in *fnlang.Interpreter) {
+
+	in.Defn("core/atom", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("atom expects 1 argument, got %d", len(args))
+		}
+		return ctx.Yield(context.NewRefValue(context.NewRef(args[0])))
+	})
+
+	in.Defn("core/reset!", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("reset! expects 2 arguments, got %d", len(args))
+		}
+		ref, err := refArgument("reset!", args[0])
+		if err != nil {
+			return err
+		}
+		if _, err := ref.Reset(ctx, args[1]); err != nil {
+			return err
+		}
+		return ctx.Yield(args[1])
+	})
+
+	in.Defn("core/swap!", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) < 2 {
+			return fmt.Errorf("swap! expects at least 2 arguments, got %d", len(args))
+		}
+		ref, err := refArgument("swap!", args[0])
+		if err != nil {
+			return err
+		}
+		fn := args[1]
+		value, err := ref.Swap(ctx, func(old *context.Value) (*context.Value, error) {
+			return call(ctx, fn, append([]*context.Value{old}, args[2:]...)...)
+		})
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(value)
+	})
+
+	in.Defn("core/compare-and-set!", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 3 {
+			return fmt.Errorf("compare-and-set! expects 3 arguments, got %d", len(args))
+		}
+		ref, err := refArgument("compare-and-set!", args[0])
+		if err != nil {
+			return err
+		}
+		ok, err := ref.CompareAndSet(ctx, args[1], args[2])
+		if err != nil {
+			return err
+		}
+		return yieldBool(ctx, ok)
+	})
+
+	in.Defn("core/add-watch", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 3 {
+			return fmt.Errorf("add-watch expects 3 arguments, got %d", len(args))
+		}
+		ref, err := refArgument("add-watch", args[0])
+		if err != nil {
+			return err
+		}
+		refValue, fn := args[0], args[2]
+		if fn.Type() != context.ValueTypeFunction {
+			return fmt.Errorf("%v is not a function", fn)
+		}
+		ref.Watch(args[1], func(ctx *context.Context, key *context.Value, old *context.Value, new *context.Value) error {
+			_, err := call(ctx, fn, key, refValue, old, new)
+			return err
+		})
+		return ctx.Yield(refValue)
+	})
+
+	in.Defn("core/remove-watch", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("remove-watch expects 2 arguments, got %d", len(args))
+		}
+		ref, err := refArgument("remove-watch", args[0])
+		if err != nil {
+			return err
+		}
+		return yieldBool(ctx, ref.Unwatch(args[1]))
+	})
+
+}
@@ -0,0 +1,620 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+// call calls fn with the given arguments and returns its result. The
+// arguments are passed as they are, without evaluating them again.
+func call(ctx *context.Context, fn *context.Value, args ...*context.Value) (*context.Value, error) {
+	if fn.Type() != context.ValueTypeFunction {
+		return nil, fmt.Errorf("%v is not a function", fn)
+	}
+
+	if err := ctx.Step(); err != nil {
+		return nil, err
+	}
+
+	var values []*context.Value
+	if lambda := fn.Lambda(); lambda != nil && !lambda.Macro && !lambda.Generator {
+		var err error
+		if values, _, err = runLambda(ctx, lambda, args); err != nil {
+			return nil, err
+		}
+	} else {
+		callCtx := context.New(ctx).Name("call").NonExecutable()
+
+		go func() {
+			defer callCtx.Close()
+			for i := 0; i < len(args) && callCtx.Accept(); i++ {
+				callCtx.Push(args[i])
+			}
+		}()
+
+		fnErr := make(chan error, 1)
+		go func() {
+			defer callCtx.Exit(nil)
+			fnErr <- fn.Function().Exec(callCtx)
+		}()
+
+		results, err := callCtx.Results()
+		if err != nil {
+			return nil, err
+		}
+		if err := <-fnErr; err != nil {
+			return nil, err
+		}
+		values = results.List()
+	}
+
+	switch len(values) {
+	case 0:
+		return context.Nil, nil
+	case 1:
+		return values[0], nil
+	}
+	return ctx.Alloc(context.NewListValue(values))
+}
+
+func listArgument(name string, value *context.Value) ([]*context.Value, error) {
+	if value.Type() != context.ValueTypeList {
+		return nil, fmt.Errorf("%s expects a list, got %v", name, value.Type())
+	}
+	return value.List(), nil
+}
+
+// fnListArguments reads the arguments of a function that takes a function
+// and a list.
+func fnListArguments(ctx *context.Context, name string) (*context.Value, []*context.Value, error) {
+	args, err := ctx.Arguments()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args) != 2 {
+		return nil, nil, fmt.Errorf("%s expects 2 arguments, got %d", name, len(args))
+	}
+	list, err := listArgument(name, args[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return args[0], list, nil
+}
+
+// yieldList yields a new list made of items, which must not be shared with
+// any other list.
+func yieldList(ctx *context.Context, items []*context.Value) error {
+	list, err := ctx.Alloc(context.NewListValue(items))
+	if err != nil {
+		return err
+	}
+	return ctx.Yield(list)
+}
+
+// maxLength bounds the size of the lists, strings and channel buffers that
+// builtins build from a count they are given, limits or not, so that a huge
+// count fails with an error instead of taking the process down.
+const maxLength = 1 << 28
+
+// checkListLength fails if a list of n items would go over the memory limits
+// of ctx, so that huge lists are not built only to be rejected.
+func checkListLength(ctx *context.Context, n int) error {
+	if n > maxLength {
+		return fmt.Errorf("list of %d items is too long", n)
+	}
+	if mem := ctx.Memory(); mem != nil {
+		if max := mem.Limits().MaxListLength; max > 0 && n > max {
+			return fmt.Errorf("%w: list of %d items is longer than %d", context.ErrLimitExceeded, n, max)
+		}
+	}
+	return nil
+}
+
+// filterFunc defines a function that keeps the items of a list for which
+// the predicate returns keep. Sequences are filtered lazily.
+func filterFunc(in *fnlang.Interpreter, name string, keep bool) {
+	in.Defn(name, func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("%s expects 2 arguments, got %d", name, len(args))
+		}
+		pred := args[0]
+		if seq, ok := seqArgument(args[1]); ok {
+			i := 0
+			return yieldSeq(ctx, func(ctx *context.Context) (*context.Value, bool, error) {
+				for {
+					item, ok, err := seq.Nth(ctx, i)
+					if err != nil || !ok {
+						return nil, false, err
+					}
+					i++
+					value, err := call(ctx, pred, item)
+					if err != nil {
+						return nil, false, err
+					}
+					if truthy(value) == keep {
+						return item, true, nil
+					}
+				}
+			})
+		}
+		list, err := listArgument(name, args[1])
+		if err != nil {
+			return err
+		}
+		items := []*context.Value{}
+		for i := range list {
+			ok, err := call(ctx, pred, list[i])
+			if err != nil {
+				return err
+			}
+			if truthy(ok) == keep {
+				items = append(items, list[i])
+			}
+		}
+		return yieldList(ctx, items)
+	})
+}
+
+// sliceFunc defines a function that takes a count and a list and returns a
+// part of the list. Sequences are handed to sliceSeq instead.
+func sliceFunc(in *fnlang.Interpreter, name string, slice func(list []*context.Value, n int) []*context.Value, sliceSeq func(ctx *context.Context, seq *context.Seq, n int) error) {
+	in.Defn(name, func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("%s expects 2 arguments, got %d", name, len(args))
+		}
+		n, err := intArgument(name, args[0])
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			n = 0
+		}
+		if seq, ok := seqArgument(args[1]); ok {
+			return sliceSeq(ctx, seq, n)
+		}
+		list, err := listArgument(name, args[1])
+		if err != nil {
+			return err
+		}
+		if n > len(list) {
+			n = len(list)
+		}
+		return yieldList(ctx, append([]*context.Value{}, slice(list, n)...))
+	})
+}
+
+func installSeq(in *fnlang.Interpreter) {
+
+	in.Defn("core/map", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) < 2 {
+			return errors.New("map expects a function and at least one list")
+		}
+		lazy := false
+		for _, arg := range args[1:] {
+			if err := collArgument("map", arg); err != nil {
+				return err
+			}
+			if _, ok := seqArgument(arg); ok {
+				lazy = true
+			}
+		}
+		if lazy {
+			// Sequences may be infinite, so the result is a sequence too and
+			// the function is only called on the items that are taken.
+			fn, colls := args[0], args[1:]
+			i := 0
+			return yieldSeq(ctx, func(ctx *context.Context) (*context.Value, bool, error) {
+				fnArgs := make([]*context.Value, len(colls))
+				for j := range colls {
+					item, ok, err := nthItem(ctx, colls[j], i)
+					if err != nil || !ok {
+						return nil, false, err
+					}
+					fnArgs[j] = item
+				}
+				i++
+				item, err := call(ctx, fn, fnArgs...)
+				if err != nil {
+					return nil, false, err
+				}
+				return item, true, nil
+			})
+		}
+		lists := [][]*context.Value{}
+		n := -1
+		for _, arg := range args[1:] {
+			list, err := listArgument("map", arg)
+			if err != nil {
+				return err
+			}
+			if n < 0 || len(list) < n {
+				n = len(list)
+			}
+			lists = append(lists, list)
+		}
+		items := make([]*context.Value, 0, n)
+		for i := 0; i < n; i++ {
+			fnArgs := make([]*context.Value, len(lists))
+			for j := range lists {
+				fnArgs[j] = lists[j][i]
+			}
+			item, err := call(ctx, args[0], fnArgs...)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		return yieldList(ctx, items)
+	})
+
+	filterFunc(in, "core/filter", true)
+	filterFunc(in, "core/remove", false)
+
+	in.Defn("core/reduce", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		var acc *context.Value
+		var list []*context.Value
+		switch len(args) {
+		case 2:
+			if list, err = listArgument("reduce", args[1]); err != nil {
+				return err
+			}
+			if len(list) == 0 {
+				return ctx.Yield(context.Nil)
+			}
+			acc, list = list[0], list[1:]
+		case 3:
+			if list, err = listArgument("reduce", args[2]); err != nil {
+				return err
+			}
+			acc = args[1]
+		default:
+			return fmt.Errorf("reduce expects 2 or 3 arguments, got %d", len(args))
+		}
+		for i := range list {
+			if acc, err = call(ctx, args[0], acc, list[i]); err != nil {
+				return err
+			}
+		}
+		return ctx.Yield(acc)
+	})
+
+	in.Defn("core/some", func(ctx *context.Context) error {
+		pred, list, err := fnListArguments(ctx, "some")
+		if err != nil {
+			return err
+		}
+		for i := range list {
+			value, err := call(ctx, pred, list[i])
+			if err != nil {
+				return err
+			}
+			if truthy(value) {
+				return ctx.Yield(value)
+			}
+		}
+		return ctx.Yield(context.Nil)
+	})
+
+	in.Defn("core/every?", func(ctx *context.Context) error {
+		pred, list, err := fnListArguments(ctx, "every?")
+		if err != nil {
+			return err
+		}
+		for i := range list {
+			value, err := call(ctx, pred, list[i])
+			if err != nil {
+				return err
+			}
+			if !truthy(value) {
+				return yieldBool(ctx, false)
+			}
+		}
+		return yieldBool(ctx, true)
+	})
+
+	in.Defn("core/count", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("count expects 1 argument, got %d", len(args))
+		}
+		switch args[0].Type() {
+		case context.ValueTypeList:
+			return ctx.Yield(context.NewIntValue(int64(len(args[0].List()))))
+		case context.ValueTypeMap:
+			return ctx.Yield(context.NewIntValue(int64(args[0].Map().Len())))
+		case context.ValueTypeString:
+			return ctx.Yield(context.NewIntValue(int64(utf8.RuneCountInString(args[0].Symbol()))))
+		}
+		if context.Eq(args[0], context.Nil) {
+			return ctx.Yield(context.NewIntValue(0))
+		}
+		return fmt.Errorf("count expects a collection, got %v", args[0].Type())
+	})
+
+	in.Defn("core/first", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("first expects 1 argument, got %d", len(args))
+		}
+		if seq, ok := seqArgument(args[0]); ok {
+			item, ok, err := seq.Nth(ctx, 0)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ctx.Yield(context.Nil)
+			}
+			return ctx.Yield(item)
+		}
+		list, err := listArgument("first", args[0])
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return ctx.Yield(context.Nil)
+		}
+		return ctx.Yield(list[0])
+	})
+
+	in.Defn("core/rest", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("rest expects 1 argument, got %d", len(args))
+		}
+		list, err := listArgument("rest", args[0])
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return yieldList(ctx, []*context.Value{})
+		}
+		return yieldList(ctx, append([]*context.Value{}, list[1:]...))
+	})
+
+	in.Defn("core/cons", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("cons expects 2 arguments, got %d", len(args))
+		}
+		list, err := listArgument("cons", args[1])
+		if err != nil {
+			return err
+		}
+		return yieldList(ctx, append([]*context.Value{args[0]}, list...))
+	})
+
+	in.Defn("core/concat", func(ctx *context.Context) error {
+		items := []*context.Value{}
+		for ctx.Next() {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			list, err := listArgument("concat", arg)
+			if err != nil {
+				return err
+			}
+			items = append(items, list...)
+		}
+		return yieldList(ctx, items)
+	})
+
+	in.Defn("core/reverse", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("reverse expects 1 argument, got %d", len(args))
+		}
+		list, err := listArgument("reverse", args[0])
+		if err != nil {
+			return err
+		}
+		items := make([]*context.Value, len(list))
+		for i := range list {
+			items[len(list)-1-i] = list[i]
+		}
+		return yieldList(ctx, items)
+	})
+
+	in.Defn("core/range", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		bounds := []int{0, 0, 1}
+		switch len(args) {
+		case 1:
+			if bounds[1], err = intArgument("range", args[0]); err != nil {
+				return err
+			}
+		case 2, 3:
+			for i := range args {
+				if bounds[i], err = intArgument("range", args[i]); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("range expects 1 to 3 arguments, got %d", len(args))
+		}
+		start, end, step := bounds[0], bounds[1], bounds[2]
+		if step == 0 {
+			return errors.New("range expects a non-zero step")
+		}
+		// The distance between start and end is taken as an unsigned number,
+		// which holds any distance between two integers.
+		var count uint64
+		if step > 0 && end > start {
+			count = (uint64(end)-uint64(start)-1)/uint64(step) + 1
+		} else if step < 0 && end < start {
+			count = (uint64(start)-uint64(end)-1)/(-uint64(step)) + 1
+		}
+		if count > maxLength {
+			return fmt.Errorf("range of %d items is too long", count)
+		}
+		n := int(count)
+		if err := checkListLength(ctx, n); err != nil {
+			return err
+		}
+		items := make([]*context.Value, n)
+		for i := range items {
+			items[i] = context.NewIntValue(int64(start + i*step))
+		}
+		return yieldList(ctx, items)
+	})
+
+	sliceFunc(in, "core/take", func(list []*context.Value, n int) []*context.Value {
+		return list[:n]
+	}, func(ctx *context.Context, seq *context.Seq, n int) error {
+		if err := checkListLength(ctx, n); err != nil {
+			return err
+		}
+		items := []*context.Value{}
+		for i := 0; i < n; i++ {
+			item, ok, err := seq.Nth(ctx, i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			items = append(items, item)
+		}
+		return yieldList(ctx, items)
+	})
+
+	sliceFunc(in, "core/drop", func(list []*context.Value, n int) []*context.Value {
+		return list[n:]
+	}, func(ctx *context.Context, seq *context.Seq, n int) error {
+		i := n
+		return yieldSeq(ctx, func(ctx *context.Context) (*context.Value, bool, error) {
+			item, ok, err := seq.Nth(ctx, i)
+			if ok {
+				i++
+			}
+			return item, ok, err
+		})
+	})
+
+	in.Defn("core/nth", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("nth expects 2 or 3 arguments, got %d", len(args))
+		}
+		i, err := intArgument("nth", args[1])
+		if err != nil {
+			return err
+		}
+		if seq, ok := seqArgument(args[0]); ok {
+			if i >= 0 {
+				item, ok, err := seq.Nth(ctx, i)
+				if err != nil {
+					return err
+				}
+				if ok {
+					return ctx.Yield(item)
+				}
+			}
+			if len(args) == 3 {
+				return ctx.Yield(args[2])
+			}
+			return fmt.Errorf("nth: index %d out of bounds", i)
+		}
+		list, err := listArgument("nth", args[0])
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= len(list) {
+			if len(args) == 3 {
+				return ctx.Yield(args[2])
+			}
+			return fmt.Errorf("nth: index %d out of bounds for length %d", i, len(list))
+		}
+		return ctx.Yield(list[i])
+	})
+
+	in.Defn("core/sort", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("sort expects 1 argument, got %d", len(args))
+		}
+		list, err := listArgument("sort", args[0])
+		if err != nil {
+			return err
+		}
+		items := append([]*context.Value{}, list...)
+		sort.SliceStable(items, func(i, j int) bool {
+			return context.Compare(items[i], items[j]) < 0
+		})
+		return yieldList(ctx, items)
+	})
+
+	in.Defn("core/sort-by", func(ctx *context.Context) error {
+		fn, list, err := fnListArguments(ctx, "sort-by")
+		if err != nil {
+			return err
+		}
+		// Keys are computed once for each item, before sorting, so that fn
+		// is not called again on every comparison.
+		type keyed struct {
+			key, item *context.Value
+		}
+		items := make([]keyed, len(list))
+		for i := range list {
+			key, err := call(ctx, fn, list[i])
+			if err != nil {
+				return err
+			}
+			items[i] = keyed{key: key, item: list[i]}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return context.Compare(items[i].key, items[j].key) < 0
+		})
+		sorted := make([]*context.Value, len(items))
+		for i := range items {
+			sorted[i] = items[i].item
+		}
+		return yieldList(ctx, sorted)
+	})
+
+}
@@ -3,10 +3,10 @@ package stdlib
 import (
 	"errors"
 	"fmt"
-	"log"
 
 	"github.com/xiam/fnlang"
 	"github.com/xiam/fnlang/context"
+	"github.com/xiam/sexpr/ast"
 )
 
 func execFunctionBody(ctx *context.Context, body *context.Value) error {
@@ -48,42 +48,391 @@ func execFunctionBody(ctx *context.Context, body *context.Value) error {
 		ctx.Yield(values)
 		return nil
 	default:
-		log.Fatalf("unhandled type: %v", body.Type())
-		panic("unhandled")
+		value, err := context.ExecArgument(ctx, body)
+		if err != nil {
+			return fnlang.RuntimeError(ctx, body.Node(), err)
+		}
+		ctx.Yield(value)
+		return nil
+	}
+}
+
+// newLambda creates the function value for lambda. Arguments are evaluated
+// on the context of the caller before the body runs on a scope of its own.
+func newLambda(lambda *context.Lambda) *context.Value {
+	return context.NewLambdaValue(lambda, func(ctx *context.Context) error {
+		args := []*context.Value{}
+		for i := 0; i < len(lambda.Params) && ctx.Next(); i++ {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			args = append(args, arg)
+		}
+		if lambda.Generator {
+			return ctx.Yield(newGenerator(ctx, lambda, args))
+		}
+		return callLambda(ctx, lambda, args)
+	})
+}
+
+// callLambda runs the body of lambda and yields its results on ctx.
+func callLambda(ctx *context.Context, lambda *context.Lambda, args []*context.Value) error {
+	values, lambda, err := runLambda(ctx, lambda, args)
+	if err != nil {
+		return fnlang.RuntimeError(ctx, lambda.Body.Node(), err)
+	}
+	return ctx.Yield(values...)
+}
+
+// runLambda runs the body of lambda and returns its results along with the
+// lambda that produced them. Tail calls returned by the body are made in a
+// loop, each of them on a new scope that replaces the previous one, so that
+// tail recursion runs in constant space.
+func runLambda(ctx *context.Context, lambda *context.Lambda, args []*context.Value) ([]*context.Value, *context.Lambda, error) {
+	var node *ast.Node
+	for {
+		scope := context.New(ctx).Name(lambda.Name).Executable()
+		if node != nil {
+			scope.At(node)
+		}
+		scope.Enter(lambda.Name)
+		for i := 0; i < len(lambda.Params) && i < len(args); i++ {
+			if err := scope.Set(lambda.Params[i].Symbol(), args[i]); err != nil {
+				return nil, lambda, err
+			}
+		}
+
+		values, err := execLambdaBody(scope, lambda.Body)
+		if tc, ok := err.(*context.TailCall); ok {
+			if tc.Fn != nil {
+				lambda = tc.Fn.Lambda()
+			}
+			args, node = tc.Args, tc.Node
+			continue
+		}
+		return values, lambda, err
+	}
+}
+
+// newMacro creates the function value for a macro. The arguments are bound
+// unevaluated and the code returned by the body is evaluated in place of the
+// call.
+func newMacro(lambda *context.Lambda) *context.Value {
+	return context.NewLambdaValue(lambda, func(ctx *context.Context) error {
+		args := []*context.Value{}
+		for i := 0; i < len(lambda.Params) && ctx.NonExecutable().Next(); i++ {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			args = append(args, arg)
+		}
+		ctx.Executable()
+
+		expansion, err := expandMacro(ctx, lambda, args)
+		if err != nil {
+			return err
+		}
+
+		value, err := evalTailValue(ctx, expansion, ctx.IsTail())
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(value)
+	})
+}
+
+func expandMacro(ctx *context.Context, lambda *context.Lambda, args []*context.Value) (*context.Value, error) {
+	values, _, err := runLambda(ctx, lambda, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) < 1 {
+		return context.Nil, nil
+	}
+	return values[len(values)-1], nil
+}
+
+// macroFor returns the macro form calls, if any.
+func macroFor(ctx *context.Context, form *context.Value) *context.Lambda {
+	forms := form.Expression()
+	if len(forms) < 1 {
+		return nil
+	}
+	var name string
+	switch forms[0].Type() {
+	case context.ValueTypeSymbol:
+		name = forms[0].Symbol()
+	case context.ValueTypeAtom:
+		name = forms[0].Atom()
+	default:
+		return nil
+	}
+	fn, err := ctx.Get(name)
+	if err != nil || fn.Lambda() == nil || !fn.Lambda().Macro {
+		return nil
+	}
+	return fn.Lambda()
+}
+
+// quasiquote returns a copy of form in which the (unquote x) forms are
+// replaced by the value of x and the (unquote-splicing x) forms by the items
+// of the list x.
+func quasiquote(ctx *context.Context, form *context.Value) (*context.Value, error) {
+	if forms := form.Expression(); forms != nil {
+		if isCall(form, "unquote") {
+			if len(forms) != 2 {
+				return nil, errors.New("unquote expects one argument")
+			}
+			return evalValue(ctx, forms[1])
+		}
+		values, err := quasiquoteList(ctx, forms)
+		if err != nil {
+			return nil, err
+		}
+		return fnlang.NewExpression(form.Node(), values), nil
+	}
+
+	switch form.Type() {
+	case context.ValueTypeList:
+		values, err := quasiquoteList(ctx, form.List())
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Alloc(context.NewListValue(values))
+	case context.ValueTypeMap:
+		m := context.NewMap()
+		for _, k := range form.Map().Keys() {
+			v, _ := form.Map().Get(k)
+			value, err := quasiquote(ctx, v)
+			if err != nil {
+				return nil, err
+			}
+			m.Set(k, value)
+		}
+		return ctx.Alloc(context.NewMapValue(m))
+	}
+
+	return form, nil
+}
+
+func quasiquoteList(ctx *context.Context, forms []*context.Value) ([]*context.Value, error) {
+	values := []*context.Value{}
+	for i := range forms {
+		if isCall(forms[i], "unquote-splicing") {
+			args := forms[i].Expression()
+			if len(args) != 2 {
+				return nil, errors.New("unquote-splicing expects one argument")
+			}
+			value, err := evalValue(ctx, args[1])
+			if err != nil {
+				return nil, err
+			}
+			if value.Type() != context.ValueTypeList {
+				return nil, fmt.Errorf("unquote-splicing expects a list, got %v", value.Type())
+			}
+			values = append(values, value.List()...)
+			continue
+		}
+		value, err := quasiquote(ctx, forms[i])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// isCall reports whether form is an unevaluated call to the named function.
+func isCall(form *context.Value, name string) bool {
+	forms := form.Expression()
+	if len(forms) < 1 {
+		return false
+	}
+	head := forms[0]
+	switch head.Type() {
+	case context.ValueTypeSymbol:
+		return head.Symbol() == name
+	case context.ValueTypeAtom:
+		return head.Atom() == name
+	}
+	return false
+}
+
+func execLambdaBody(ctx *context.Context, body *context.Value) ([]*context.Value, error) {
+	if body == nil {
+		return []*context.Value{context.Nil}, nil
+	}
+
+	bodyCtx := context.New(ctx).Name("exec-body").Tail()
+
+	fnErr := make(chan error, 1)
+	go func() {
+		defer bodyCtx.Exit(nil)
+		if body.Type() == context.ValueTypeFunction {
+			fnErr <- body.Function().Exec(bodyCtx)
+			return
+		}
+		fnErr <- execFunctionBody(bodyCtx, body)
+	}()
+
+	values, err := bodyCtx.Results()
+	if err != nil {
+		return nil, err
+	}
+	if err := <-fnErr; err != nil {
+		return nil, err
+	}
+	return values.List(), nil
+}
+
+// clauseName returns the name of the special form value starts with, if
+// value is an unevaluated (catch ...) or (finally ...) clause.
+func clauseName(value *context.Value) string {
+	if value.Type() != context.ValueTypeFunction || value.Node() == nil {
+		return ""
+	}
+	items := value.Node().List()
+	if len(items) < 1 || items[0].Type() != ast.NodeTypeSymbol {
+		return ""
+	}
+	switch name := items[0].Value().(string); name {
+	case "catch", "finally":
+		return name
+	}
+	return ""
+}
+
+// errorValue describes err as a map with its message and position.
+func errorValue(err error) *context.Value {
+	message, line, column := err.Error(), 0, 0
+
+	var rtErr *fnlang.Error
+	if errors.As(err, &rtErr) {
+		message, line, column = rtErr.Message, rtErr.Line, rtErr.Column
+	}
+
+	m := context.NewMap()
+	m.Set(context.NewAtomValue(":message"), context.NewStringValue(message))
+	m.Set(context.NewAtomValue(":line"), context.NewIntValue(int64(line)))
+	m.Set(context.NewAtomValue(":column"), context.NewIntValue(int64(column)))
+	return context.NewMapValue(m)
+}
+
+// execClause runs a catch or finally clause on a context where the clause
+// name is bound to fn.
+func execClause(ctx *context.Context, clause *context.Value, fn func(*context.Context) error) (*context.Value, error) {
+	clauseCtx := context.New(ctx).Name("clause").Executable()
+	if err := clauseCtx.Set(clauseName(clause), context.NewFunctionValue(fn)); err != nil {
+		return nil, err
+	}
+	return evalValue(clauseCtx, clause)
+}
+
+// evalValue evaluates value on ctx. Functions yielding more than one value
+// evaluate to the list of their results.
+func evalValue(ctx *context.Context, value *context.Value) (*context.Value, error) {
+	return execValue(ctx, value, false)
+}
+
+// evalTailValue is like evalValue but evaluates value in tail position if
+// tail is set.
+func evalTailValue(ctx *context.Context, value *context.Value, tail bool) (*context.Value, error) {
+	return execValue(ctx, value, tail)
+}
+
+func execValue(ctx *context.Context, value *context.Value, tail bool) (*context.Value, error) {
+	if value.Type() != context.ValueTypeFunction {
+		return context.ExecArgument(ctx, value)
+	}
+
+	newCtx := context.New(ctx).Name("eval-value")
+	if tail {
+		newCtx.Tail()
+	}
+	fnErr := make(chan error, 1)
+	go func() {
+		defer newCtx.Exit(nil)
+		fnErr <- value.Function().Exec(newCtx)
+	}()
+	values, err := newCtx.Results()
+	if err != nil {
+		return nil, err
+	}
+	if err := <-fnErr; err != nil {
+		return nil, err
+	}
+	if len(values.List()) == 1 {
+		return values.List()[0], nil
+	}
+	return values, nil
+}
+
+// execBody evaluates the remaining arguments of ctx and returns the last
+// result.
+func execBody(ctx *context.Context) (*context.Value, error) {
+	result := context.Nil
+	for ctx.NonExecutable().Next() {
+		arg, err := ctx.Argument()
+		if err != nil {
+			return nil, err
+		}
+		result, err = evalValue(ctx.Executable(), arg)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return result, nil
 }
 
 func init() {
+	Install(fnlang.DefaultInterpreter())
+}
 
-	fnlang.Defn("when", func(ctx *context.Context) error {
-		for {
-			if !ctx.Next() {
-				break
+// Install defines the standard library on the given interpreter.
+func Install(in *fnlang.Interpreter) {
+
+	in.Defn("core/when", func(ctx *context.Context) error {
+		args := []*context.Value{}
+		for ctx.NonExecutable().Next() {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
 			}
-			cond, err := ctx.Argument()
+			args = append(args, arg)
+		}
+		ctx.Executable()
+
+		for i := 0; i+1 < len(args); i += 2 {
+			cond, err := context.ExecArgument(ctx, args[i])
 			if err != nil {
 				return err
 			}
-			if ctx.Next() {
-				if context.Eq(cond, context.True) {
-					value, err := ctx.Argument()
-					if err != nil {
-						return err
-					}
-					ctx.Yield(value)
-					return nil
+			if context.Eq(cond, context.True) {
+				value, err := context.ExecTailArgument(ctx, args[i+1])
+				if err != nil {
+					return err
 				}
-			} else {
-				ctx.Yield(cond)
+				ctx.Yield(value)
 				return nil
 			}
 		}
 
+		if len(args)%2 == 1 {
+			value, err := context.ExecTailArgument(ctx, args[len(args)-1])
+			if err != nil {
+				return err
+			}
+			ctx.Yield(value)
+			return nil
+		}
+
 		ctx.Yield(context.Nil)
 		return nil
 	})
 
-	fnlang.Defn("push", func(ctx *context.Context) error {
+	in.Defn("core/push", func(ctx *context.Context) error {
 		var name *context.Value
 		var err error
 
@@ -103,21 +452,27 @@ func init() {
 			return nil
 		}
 
-		list := value.List()
+		// The list is copied so that appending never writes into an array
+		// that other values still share.
+		list := append([]*context.Value{}, value.List()...)
 		for ctx.Executable().Next() {
 			value, err := ctx.Argument()
 			if err != nil {
 				return err
 			}
 			list = append(list, value)
-			ctx.Parent.Set(name.Symbol(), context.NewListValue(list))
+			newList, err := ctx.Alloc(context.NewListValue(list))
+			if err != nil {
+				return err
+			}
+			ctx.Parent.Set(name.Symbol(), newList)
 		}
 
 		ctx.Yield(context.True)
 		return nil
 	})
 
-	fnlang.Defn("-", func(ctx *context.Context) error {
+	in.Defn("core/-", func(ctx *context.Context) error {
 		result := (interface{})(int64(0))
 		for i := 0; ctx.Next(); i++ {
 			value, err := ctx.Argument()
@@ -145,7 +500,7 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("+", func(ctx *context.Context) error {
+	in.Defn("core/+", func(ctx *context.Context) error {
 		result := (interface{})(int64(0))
 		for ctx.Next() {
 			value, err := ctx.Argument()
@@ -168,7 +523,7 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("/", func(ctx *context.Context) error {
+	in.Defn("core//", func(ctx *context.Context) error {
 		result := (interface{})(nil)
 		for ctx.Next() {
 			value, err := ctx.Argument()
@@ -184,6 +539,9 @@ func init() {
 					result = float64(result.(int64))
 				}
 			}
+			if value.Float() == 0 {
+				return errDivisionByZero
+			}
 			switch result.(type) {
 			case float64:
 				result = result.(float64) / value.Float()
@@ -198,7 +556,7 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("*", func(ctx *context.Context) error {
+	in.Defn("core/*", func(ctx *context.Context) error {
 		result := (interface{})(int64(1))
 		for ctx.Next() {
 			value, err := ctx.Argument()
@@ -221,12 +579,12 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn(":false", func(ctx *context.Context) error {
+	in.Defn(":false", func(ctx *context.Context) error {
 		ctx.Yield(context.False)
 		return nil
 	})
 
-	fnlang.Defn(":true", func(ctx *context.Context) error {
+	in.Defn(":true", func(ctx *context.Context) error {
 		for ctx.Next() {
 			_, err := ctx.Argument()
 			if err != nil {
@@ -238,7 +596,7 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("echo", func(ctx *context.Context) error {
+	in.Defn("core/echo", func(ctx *context.Context) error {
 		for ctx.Next() {
 			value, err := ctx.Argument()
 			if err != nil {
@@ -250,7 +608,7 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("=", func(ctx *context.Context) error {
+	in.Defn("core/=", func(ctx *context.Context) error {
 		var first *context.Value
 		for ctx.Next() {
 			value, err := ctx.Argument()
@@ -263,7 +621,7 @@ func init() {
 				continue
 			}
 
-			if !context.Eq(first, value) {
+			if !context.Equal(first, value) {
 				ctx.Yield(context.False)
 				return nil
 			}
@@ -273,13 +631,13 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("nop", func(ctx *context.Context) error {
+	in.Defn("core/nop", func(ctx *context.Context) error {
 		ctx.Yield(context.Nil)
 
 		return nil
 	})
 
-	fnlang.Defn("fn", func(ctx *context.Context) error {
+	in.Defn("core/fn", func(ctx *context.Context) error {
 		var params, body *context.Value
 
 		ctx = ctx.NonExecutable()
@@ -297,18 +655,15 @@ func init() {
 			}
 		}
 
-		paramsList := params.List()
-
-		wrapperFn := context.NewFunctionValue(func(ctx *context.Context) error {
-			for i := 0; ctx.Next() && i < len(paramsList); i++ {
-				arg, err := ctx.Argument()
-				if err != nil {
-					return err
-				}
-				ctx.Set(paramsList[i].Symbol(), arg)
-			}
+		if params == nil {
+			return errors.New("missing parameters list")
+		}
 
-			return execFunctionBody(ctx, body)
+		wrapperFn := newLambda(&context.Lambda{
+			Name:      "fn",
+			Params:    params.List(),
+			Body:      body,
+			Generator: body != nil && yields(body),
 		})
 
 		ctx.Yield(wrapperFn)
@@ -316,7 +671,7 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("defn", func(ctx *context.Context) error {
+	in.Defn("core/defn", func(ctx *context.Context) error {
 		var name, params, body *context.Value
 
 		ctx = ctx.NonExecutable()
@@ -339,23 +694,61 @@ func init() {
 		if params == nil {
 			return errors.New("missing parameters list")
 		}
-		paramsList := params.List()
 
-		wrapperFn := context.NewFunctionValue(func(ctx *context.Context) error {
+		wrapperFn := newLambda(&context.Lambda{
+			Name:      name.Symbol(),
+			Params:    params.List(),
+			Body:      body,
+			Generator: body != nil && yields(body),
+		})
+		wrapperFn.SetNode(body.Node())
 
-			for i := 0; ctx.Next() && i < len(paramsList); i++ {
-				arg, err := ctx.Argument()
-				if err != nil {
-					return err
-				}
-				ctx.Set(paramsList[i].Symbol(), arg)
+		if err := ctx.Parent.Set(name.Symbol(), wrapperFn); err != nil {
+			return err
+		}
+
+		ctx.Yield(context.True)
+		return nil
+	})
+
+	in.Defn("core/defmacro", func(ctx *context.Context) error {
+		var name, params, body *context.Value
+
+		ctx = ctx.NonExecutable()
+		for i := 0; ctx.Next(); i++ {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+
+			switch i {
+			case 0:
+				name = arg
+			case 1:
+				params = arg
+			default:
+				body = arg
 			}
+		}
+
+		if name == nil || name.Type() != context.ValueTypeSymbol {
+			return errors.New("missing macro name")
+		}
+		if params == nil || params.Type() != context.ValueTypeList {
+			return errors.New("missing parameters list")
+		}
 
-			return execFunctionBody(ctx, body)
+		macroFn := newMacro(&context.Lambda{
+			Name:   name.Symbol(),
+			Params: params.List(),
+			Body:   body,
+			Macro:  true,
 		})
-		wrapperFn.SetNode(body.Node())
+		if body != nil {
+			macroFn.SetNode(body.Node())
+		}
 
-		if err := ctx.Parent.Set(name.Symbol(), wrapperFn); err != nil {
+		if err := ctx.Parent.Set(name.Symbol(), macroFn); err != nil {
 			return err
 		}
 
@@ -363,14 +756,72 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("assert", func(ctx *context.Context) error {
+	in.Defn("core/quote", func(ctx *context.Context) error {
+		if !ctx.NonExecutable().Next() {
+			return errors.New("quote expects one argument")
+		}
+		form, err := ctx.Argument()
+		if err != nil {
+			return err
+		}
+		ctx.Yield(form)
+		return nil
+	})
+
+	in.Defn("core/quasiquote", func(ctx *context.Context) error {
+		if !ctx.NonExecutable().Next() {
+			return errors.New("quasiquote expects one argument")
+		}
+		form, err := ctx.Argument()
+		if err != nil {
+			return err
+		}
+		value, err := quasiquote(ctx.Executable(), form)
+		if err != nil {
+			return err
+		}
+		ctx.Yield(value)
+		return nil
+	})
+
+	in.Defn("core/unquote", func(ctx *context.Context) error {
+		return errors.New("unquote outside of quasiquote")
+	})
+
+	in.Defn("core/unquote-splicing", func(ctx *context.Context) error {
+		return errors.New("unquote-splicing outside of quasiquote")
+	})
+
+	in.Defn("core/macroexpand", func(ctx *context.Context) error {
+		if !ctx.Next() {
+			return errors.New("macroexpand expects one argument")
+		}
+		form, err := ctx.Argument()
+		if err != nil {
+			return err
+		}
+		for {
+			macro := macroFor(ctx, form)
+			if macro == nil {
+				break
+			}
+			args := form.Expression()[1:]
+			if form, err = expandMacro(ctx, macro, args); err != nil {
+				return err
+			}
+		}
+		ctx.Yield(form)
+		return nil
+	})
+
+	in.Defn("core/assert", func(ctx *context.Context) error {
 		for ctx.Next() {
 			var v1, v2 *context.Value
 			var err error
 
 			v1, err = ctx.Argument()
 			if err != nil {
-				return nil
+				return err
 			}
 
 			v2 = context.True
@@ -381,7 +832,7 @@ func init() {
 				}
 			}
 
-			if context.Eq(v1, v2) {
+			if context.Equal(v1, v2) {
 				ctx.Yield(context.True)
 				return nil
 			} else {
@@ -394,33 +845,33 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("println", func(ctx *context.Context) error {
+	in.Defn("core/println", func(ctx *context.Context) error {
 		for ctx.Next() {
 			value, err := ctx.Argument()
 			if err != nil {
 				return err
 			}
-			fmt.Printf("%s\n", value.Symbol())
+			fmt.Printf("%s\n", display(value))
 		}
 
 		ctx.Yield(context.Nil)
 		return nil
 	})
 
-	fnlang.Defn("print", func(ctx *context.Context) error {
+	in.Defn("core/print", func(ctx *context.Context) error {
 		for ctx.Next() {
 			value, err := ctx.Argument()
 			if err != nil {
 				return err
 			}
-			fmt.Printf("%s", value.Symbol())
+			fmt.Printf("%s", display(value))
 		}
 
 		ctx.Yield(context.Nil)
 		return nil
 	})
 
-	fnlang.Defn("get", func(ctx *context.Context) error {
+	in.Defn("core/get", func(ctx *context.Context) error {
 		var name *context.Value
 		ctx = ctx.NonExecutable()
 		for i := 0; ctx.Next(); i++ {
@@ -446,7 +897,156 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn("set", func(ctx *context.Context) error {
+	in.Defn("core/let", func(ctx *context.Context) error {
+		ctx = ctx.NonExecutable()
+		if !ctx.Next() {
+			return errors.New("let requires a bindings list")
+		}
+		bindings, err := ctx.Argument()
+		if err != nil {
+			return err
+		}
+		if bindings.Type() != context.ValueTypeList {
+			return errors.New("let requires a bindings list")
+		}
+
+		items := bindings.List()
+		if len(items)%2 != 0 {
+			return errors.New("let requires an even number of forms in bindings")
+		}
+
+		letCtx := context.New(ctx).Name("let").Executable()
+		for i := 0; i < len(items); i += 2 {
+			name := items[i]
+			if name.Type() != context.ValueTypeSymbol {
+				return fmt.Errorf("let cannot bind to %v", name)
+			}
+			value, err := evalValue(letCtx, items[i+1])
+			if err != nil {
+				return err
+			}
+			if err := letCtx.Set(name.Symbol(), value); err != nil {
+				return err
+			}
+		}
+
+		body := []*context.Value{}
+		for ctx.Next() {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			body = append(body, arg)
+		}
+
+		result := context.Nil
+		for i := range body {
+			result, err = evalTailValue(letCtx, body[i], i == len(body)-1 && ctx.IsTail())
+			if err != nil {
+				return err
+			}
+		}
+
+		ctx.Yield(result)
+		return nil
+	})
+
+	in.Defn("core/loop", func(ctx *context.Context) error {
+		ctx = ctx.NonExecutable()
+		if !ctx.Next() {
+			return errors.New("loop requires a bindings list")
+		}
+		bindings, err := ctx.Argument()
+		if err != nil {
+			return err
+		}
+		if bindings.Type() != context.ValueTypeList {
+			return errors.New("loop requires a bindings list")
+		}
+
+		items := bindings.List()
+		if len(items)%2 != 0 {
+			return errors.New("loop requires an even number of forms in bindings")
+		}
+
+		body := []*context.Value{}
+		for ctx.Next() {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			body = append(body, arg)
+		}
+
+		names := []string{}
+		loopCtx := context.New(ctx).Name("loop").Executable()
+		for i := 0; i < len(items); i += 2 {
+			name := items[i]
+			if name.Type() != context.ValueTypeSymbol {
+				return fmt.Errorf("loop cannot bind to %v", name)
+			}
+			value, err := evalValue(loopCtx, items[i+1])
+			if err != nil {
+				return err
+			}
+			if err := loopCtx.Set(name.Symbol(), value); err != nil {
+				return err
+			}
+			names = append(names, name.Symbol())
+		}
+
+		for {
+			result := context.Nil
+			for i := range body {
+				result, err = evalTailValue(loopCtx, body[i], i == len(body)-1)
+				if err != nil {
+					break
+				}
+			}
+
+			tc, ok := err.(*context.TailCall)
+			if !ok {
+				if err != nil {
+					return err
+				}
+				ctx.Yield(result)
+				return nil
+			}
+
+			if tc.Fn != nil {
+				// A call to a function in tail position of the loop, which is
+				// only a tail call if the loop itself is in tail position.
+				if ctx.IsTail() {
+					return tc
+				}
+				return callLambda(ctx, tc.Fn.Lambda(), tc.Args)
+			}
+
+			if len(tc.Args) != len(names) {
+				return fmt.Errorf("recur expects %d arguments, got %d", len(names), len(tc.Args))
+			}
+
+			loopCtx = context.New(ctx).Name("loop").Executable()
+			for i := range names {
+				if err := loopCtx.Set(names[i], tc.Args[i]); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	in.Defn("core/recur", func(ctx *context.Context) error {
+		if !ctx.IsTail() {
+			return errors.New("recur must be in tail position")
+		}
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		return &context.TailCall{Args: args}
+	})
+
+	in.Defn("core/set", func(ctx *context.Context) error {
 		var name, value *context.Value
 		ctx = ctx.NonExecutable()
 		for i := 0; ctx.Next(); i++ {
@@ -479,15 +1079,122 @@ func init() {
 		return nil
 	})
 
-	fnlang.Defn(":error", func(ctx *context.Context) error {
+	in.Defn(":error", func(ctx *context.Context) error {
 		for ctx.Next() {
 			value, err := ctx.Argument()
 			if err != nil {
 				return err
 			}
+			if value.Type() == context.ValueTypeString {
+				return errors.New(value.Symbol())
+			}
 			return errors.New(value.String())
 		}
 		return nil
 	})
 
+	in.Defn("core/try", func(ctx *context.Context) error {
+		var body []*context.Value
+		var catch, finally *context.Value
+
+		ctx = ctx.NonExecutable()
+		for ctx.Next() {
+			arg, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			switch clauseName(arg) {
+			case "catch":
+				if catch != nil || finally != nil {
+					return errors.New("unexpected catch clause")
+				}
+				catch = arg
+			case "finally":
+				if finally != nil {
+					return errors.New("unexpected finally clause")
+				}
+				finally = arg
+			default:
+				if catch != nil || finally != nil {
+					return errors.New("expecting catch or finally clause")
+				}
+				body = append(body, arg)
+			}
+		}
+
+		tryCtx := context.New(ctx).Name("try").Executable().WithTrap(context.NewTrap())
+
+		var err error
+		result := context.Nil
+		for i := range body {
+			result, err = evalValue(tryCtx, body[i])
+			if err == nil {
+				err = tryCtx.Trap().Err()
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		if err != nil && fnlang.IsFatal(err) {
+			return err
+		}
+
+		if err != nil && catch != nil {
+			caught := errorValue(err)
+			result, err = execClause(ctx, catch, func(ctx *context.Context) error {
+				ctx = ctx.NonExecutable()
+				if !ctx.Next() {
+					return errors.New("catch requires a symbol")
+				}
+				name, err := ctx.Argument()
+				if err != nil {
+					return err
+				}
+				if name.Type() != context.ValueTypeSymbol {
+					return errors.New("catch requires a symbol")
+				}
+				if err := ctx.Executable().Set(name.Symbol(), caught); err != nil {
+					return err
+				}
+				result, err := execBody(ctx)
+				if err != nil {
+					return err
+				}
+				return ctx.Yield(result)
+			})
+		}
+
+		if finally != nil {
+			if _, finallyErr := execClause(ctx, finally, func(ctx *context.Context) error {
+				result, err := execBody(ctx)
+				if err != nil {
+					return err
+				}
+				return ctx.Yield(result)
+			}); finallyErr != nil {
+				return finallyErr
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		ctx.Yield(result)
+		return nil
+	})
+
+	installModules(in)
+	installStr(in)
+	installCompare(in)
+	installMath(in)
+	installSeq(in)
+	installMaps(in)
+	installLazy(in)
+	installConcurrency(in)
+	installPipe(in)
+	installRefs(in)
+
+	in.Refer("core")
 }
@@ -0,0 +1,273 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xiam/fnlang"
+	"github.com/xiam/fnlang/context"
+)
+
+// display returns the form of value that is meant to be read by people:
+// strings without quotes and everything else as it is encoded.
+func display(value *context.Value) string {
+	if value.Type() == context.ValueTypeString {
+		return value.Symbol()
+	}
+	return value.String()
+}
+
+// stringArguments reads exactly n arguments of ctx, the first one being a
+// string.
+func stringArguments(ctx *context.Context, name string, n int) (string, []*context.Value, error) {
+	args, err := ctx.Arguments()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(args) != n {
+		return "", nil, fmt.Errorf("%s expects %d arguments, got %d", name, n, len(args))
+	}
+	s, err := stringArgument(name, args[0])
+	if err != nil {
+		return "", nil, err
+	}
+	return s, args[1:], nil
+}
+
+func stringArgument(name string, value *context.Value) (string, error) {
+	if value.Type() != context.ValueTypeString {
+		return "", fmt.Errorf("%s expects a string, got %v", name, value.Type())
+	}
+	return value.Symbol(), nil
+}
+
+func intArgument(name string, value *context.Value) (int, error) {
+	if value.Type() != context.ValueTypeInt {
+		return 0, fmt.Errorf("%s expects an integer, got %v", name, value.Type())
+	}
+	return int(value.Int()), nil
+}
+
+func yieldString(ctx *context.Context, s string) error {
+	value, err := ctx.Alloc(context.NewStringValue(s))
+	if err != nil {
+		return err
+	}
+	return ctx.Yield(value)
+}
+
+func yieldBool(ctx *context.Context, b bool) error {
+	if b {
+		return ctx.Yield(context.True)
+	}
+	return ctx.Yield(context.False)
+}
+
+// stringFunc defines a function that maps a string into another one.
+func stringFunc(in *fnlang.Interpreter, name string, fn func(string) string) {
+	in.Defn(name, func(ctx *context.Context) error {
+		s, _, err := stringArguments(ctx, name, 1)
+		if err != nil {
+			return err
+		}
+		return yieldString(ctx, fn(s))
+	})
+}
+
+func installStr(in *fnlang.Interpreter) {
+
+	in.Defn("core/str", func(ctx *context.Context) error {
+		buf := &strings.Builder{}
+		for ctx.Next() {
+			value, err := ctx.Argument()
+			if err != nil {
+				return err
+			}
+			buf.WriteString(display(value))
+		}
+		return yieldString(ctx, buf.String())
+	})
+
+	in.Defn("str/length", func(ctx *context.Context) error {
+		s, _, err := stringArguments(ctx, "str/length", 1)
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(context.NewIntValue(int64(utf8.RuneCountInString(s))))
+	})
+
+	in.Defn("str/substring", func(ctx *context.Context) error {
+		args, err := ctx.Arguments()
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("str/substring expects 2 or 3 arguments, got %d", len(args))
+		}
+		s, err := stringArgument("str/substring", args[0])
+		if err != nil {
+			return err
+		}
+		runes := []rune(s)
+		start, err := intArgument("str/substring", args[1])
+		if err != nil {
+			return err
+		}
+		end := len(runes)
+		if len(args) == 3 {
+			if end, err = intArgument("str/substring", args[2]); err != nil {
+				return err
+			}
+		}
+		if start < 0 || end > len(runes) || start > end {
+			return fmt.Errorf("str/substring: range [%d:%d] out of bounds for length %d", start, end, len(runes))
+		}
+		return yieldString(ctx, string(runes[start:end]))
+	})
+
+	in.Defn("str/split", func(ctx *context.Context) error {
+		s, args, err := stringArguments(ctx, "str/split", 2)
+		if err != nil {
+			return err
+		}
+		sep, err := stringArgument("str/split", args[0])
+		if err != nil {
+			return err
+		}
+		items := []*context.Value{}
+		for _, part := range strings.Split(s, sep) {
+			item, err := ctx.Alloc(context.NewStringValue(part))
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		list, err := ctx.Alloc(context.NewListValue(items))
+		if err != nil {
+			return err
+		}
+		return ctx.Yield(list)
+	})
+
+	in.Defn("str/join", func(ctx *context.Context) error {
+		sep, args, err := stringArguments(ctx, "str/join", 2)
+		if err != nil {
+			return err
+		}
+		if args[0].Type() != context.ValueTypeList {
+			return fmt.Errorf("str/join expects a list, got %v", args[0].Type())
+		}
+		parts := []string{}
+		for _, item := range args[0].List() {
+			parts = append(parts, display(item))
+		}
+		return yieldString(ctx, strings.Join(parts, sep))
+	})
+
+	stringFunc(in, "str/trim", strings.TrimSpace)
+	stringFunc(in, "str/trim-left", func(s string) string {
+		return strings.TrimLeftFunc(s, unicode.IsSpace)
+	})
+	stringFunc(in, "str/trim-right", func(s string) string {
+		return strings.TrimRightFunc(s, unicode.IsSpace)
+	})
+	stringFunc(in, "str/upper", strings.ToUpper)
+	stringFunc(in, "str/lower", strings.ToLower)
+
+	in.Defn("str/contains?", func(ctx *context.Context) error {
+		s, args, err := stringArguments(ctx, "str/contains?", 2)
+		if err != nil {
+			return err
+		}
+		sub, err := stringArgument("str/contains?", args[0])
+		if err != nil {
+			return err
+		}
+		return yieldBool(ctx, strings.Contains(s, sub))
+	})
+
+	in.Defn("str/starts-with?", func(ctx *context.Context) error {
+		s, args, err := stringArguments(ctx, "str/starts-with?", 2)
+		if err != nil {
+			return err
+		}
+		prefix, err := stringArgument("str/starts-with?", args[0])
+		if err != nil {
+			return err
+		}
+		return yieldBool(ctx, strings.HasPrefix(s, prefix))
+	})
+
+	in.Defn("str/ends-with?", func(ctx *context.Context) error {
+		s, args, err := stringArguments(ctx, "str/ends-with?", 2)
+		if err != nil {
+			return err
+		}
+		suffix, err := stringArgument("str/ends-with?", args[0])
+		if err != nil {
+			return err
+		}
+		return yieldBool(ctx, strings.HasSuffix(s, suffix))
+	})
+
+	in.Defn("str/index", func(ctx *context.Context) error {
+		s, args, err := stringArguments(ctx, "str/index", 2)
+		if err != nil {
+			return err
+		}
+		sub, err := stringArgument("str/index", args[0])
+		if err != nil {
+			return err
+		}
+		i := strings.Index(s, sub)
+		if i < 0 {
+			return ctx.Yield(context.Nil)
+		}
+		return ctx.Yield(context.NewIntValue(int64(utf8.RuneCountInString(s[:i]))))
+	})
+
+	in.Defn("str/replace", func(ctx *context.Context) error {
+		s, args, err := stringArguments(ctx, "str/replace", 3)
+		if err != nil {
+			return err
+		}
+		old, err := stringArgument("str/replace", args[0])
+		if err != nil {
+			return err
+		}
+		with, err := stringArgument("str/replace", args[1])
+		if err != nil {
+			return err
+		}
+		return yieldString(ctx, strings.ReplaceAll(s, old, with))
+	})
+
+	in.Defn("str/repeat", func(ctx *context.Context) error {
+		s, args, err := stringArguments(ctx, "str/repeat", 2)
+		if err != nil {
+			return err
+		}
+		n, err := intArgument("str/repeat", args[0])
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("str/repeat expects a non-negative count")
+		}
+		if mem := ctx.Memory(); mem != nil && n > 0 {
+			// Check the limit before building a string that could be huge.
+			max := mem.Limits().MaxStringLength
+			if length := utf8.RuneCountInString(s); max > 0 && length > max/n {
+				return fmt.Errorf("%w: string of %d characters repeated %d times is longer than %d", context.ErrLimitExceeded, length, n, max)
+			}
+		}
+		if len(s) > 0 && n > maxLength/len(s) {
+			return fmt.Errorf("str/repeat: string of %d bytes repeated %d times is too long", len(s), n)
+		}
+		return yieldString(ctx, strings.Repeat(s, n))
+	})
+
+}